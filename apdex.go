@@ -0,0 +1,156 @@
+package ginprometheus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	apdexSatisfied  = "satisfied"
+	apdexTolerating = "tolerating"
+	apdexFrustrated = "frustrated"
+
+	apdexSlots    = 10
+	apdexSlotSize = 30 * time.Second
+)
+
+// SetApdexTarget sets the Apdex target T used for every handler without a
+// route-specific target. Requests are satisfied within T, tolerating within
+// 4T and frustrated beyond that or when they fail with a 5xx. It must be
+// called before serving requests.
+func (p *Prometheus) SetApdexTarget(t time.Duration) {
+	p.enableApdex()
+	p.apdex.target = t
+}
+
+// SetRouteApdexTarget sets the Apdex target T for a single handler label
+// value. It must be called before serving requests.
+func (p *Prometheus) SetRouteApdexTarget(handler string, t time.Duration) {
+	p.enableApdex()
+	p.apdex.targets[handler] = t
+}
+
+func (p *Prometheus) enableApdex() {
+	if p.apdex != nil {
+		return
+	}
+	p.apdex = &apdexTracker{
+		targets: make(map[string]time.Duration),
+		windows: make(map[string]*apdexWindow),
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName("", p.subsystem, "apdex_score"),
+			"The Apdex score over the last five minutes.",
+			[]string{"handler"}, nil,
+		),
+		reqs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: p.subsystem,
+				Name:      "apdex_requests_total",
+				Help:      "How many HTTP requests processed, partitioned by handler and Apdex zone.",
+			},
+			[]string{"handler", "zone"},
+		),
+	}
+	p.registerer.MustRegister(p.apdex.reqs)
+	p.registerer.MustRegister(p.apdex)
+}
+
+type apdexTracker struct {
+	target  time.Duration
+	targets map[string]time.Duration
+
+	desc *prometheus.Desc
+	reqs *prometheus.CounterVec
+
+	mu      sync.Mutex
+	windows map[string]*apdexWindow
+}
+
+func (a *apdexTracker) observe(handler string, code int, elapsed time.Duration) {
+	t, ok := a.targets[handler]
+	if !ok {
+		t = a.target
+	}
+	if t <= 0 {
+		return
+	}
+
+	zone := apdexFrustrated
+	switch {
+	case code >= 500 && code <= 599:
+	case elapsed <= t:
+		zone = apdexSatisfied
+	case elapsed <= 4*t:
+		zone = apdexTolerating
+	}
+	a.reqs.WithLabelValues(handler, zone).Inc()
+
+	a.mu.Lock()
+	w, ok := a.windows[handler]
+	if !ok {
+		w = &apdexWindow{}
+		a.windows[handler] = w
+	}
+	w.add(time.Now(), zone)
+	a.mu.Unlock()
+}
+
+func (a *apdexTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.desc
+}
+
+func (a *apdexTracker) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for handler, w := range a.windows {
+		if score, ok := w.score(now); ok {
+			ch <- prometheus.MustNewConstMetric(a.desc, prometheus.GaugeValue, score, handler)
+		}
+	}
+}
+
+type apdexSlot struct {
+	epoch                        int64
+	satisfied, tolerating, total float64
+}
+
+// apdexWindow is a ring of time slots covering the rolling window.
+type apdexWindow struct {
+	slots [apdexSlots]apdexSlot
+}
+
+func (w *apdexWindow) add(now time.Time, zone string) {
+	epoch := now.UnixNano() / int64(apdexSlotSize)
+	s := &w.slots[epoch%apdexSlots]
+	if s.epoch != epoch {
+		*s = apdexSlot{epoch: epoch}
+	}
+	switch zone {
+	case apdexSatisfied:
+		s.satisfied++
+	case apdexTolerating:
+		s.tolerating++
+	}
+	s.total++
+}
+
+func (w *apdexWindow) score(now time.Time) (float64, bool) {
+	epoch := now.UnixNano() / int64(apdexSlotSize)
+	var satisfied, tolerating, total float64
+	for _, s := range w.slots {
+		if epoch-s.epoch < apdexSlots {
+			satisfied += s.satisfied
+			tolerating += s.tolerating
+			total += s.total
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return (satisfied + tolerating/2) / total, true
+}
@@ -0,0 +1,183 @@
+package ginprometheus
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const defaultCardinalityTop = 10
+
+// CardinalityReport lists the series of every gathered metric, to find the
+// labels that explode.
+type CardinalityReport struct {
+	Metrics []MetricCardinality `json:"metrics"`
+
+	// Overflow reports series and measurements that were dropped, such as
+	// series_expired_total and the metrics StatsD sinks could not queue.
+	Overflow map[string]float64 `json:"overflow"`
+}
+
+// MetricCardinality is the number of label sets of one metric family.
+type MetricCardinality struct {
+	Name   string             `json:"name"`
+	Type   string             `json:"type"`
+	Series int                `json:"series"`
+	Labels []LabelCardinality `json:"labels"`
+}
+
+// LabelCardinality is the number of distinct values of one label, with the
+// values carried by the most series.
+type LabelCardinality struct {
+	Name   string            `json:"name"`
+	Values int               `json:"values"`
+	Top    []LabelValueCount `json:"top"`
+}
+
+// LabelValueCount is the number of series carrying a label value.
+type LabelValueCount struct {
+	Value  string `json:"value"`
+	Series int    `json:"series"`
+}
+
+// Cardinality gathers the instance's registry and reports the series
+// count of each metric, largest first, with the top values of each label.
+func (p *Prometheus) Cardinality(top int) (*CardinalityReport, error) {
+	mfs, err := p.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CardinalityReport{Overflow: p.overflow()}
+	for _, mf := range mfs {
+		mc := MetricCardinality{
+			Name:   mf.GetName(),
+			Type:   strings.ToLower(mf.GetType().String()),
+			Series: len(mf.GetMetric()),
+		}
+
+		values := make(map[string]map[string]int)
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if values[lp.GetName()] == nil {
+					values[lp.GetName()] = make(map[string]int)
+				}
+				values[lp.GetName()][lp.GetValue()]++
+			}
+		}
+		for name, counts := range values {
+			lc := LabelCardinality{Name: name, Values: len(counts)}
+			for value, n := range counts {
+				lc.Top = append(lc.Top, LabelValueCount{Value: value, Series: n})
+			}
+			sort.Slice(lc.Top, func(i, j int) bool {
+				if lc.Top[i].Series != lc.Top[j].Series {
+					return lc.Top[i].Series > lc.Top[j].Series
+				}
+				return lc.Top[i].Value < lc.Top[j].Value
+			})
+			if len(lc.Top) > top {
+				lc.Top = lc.Top[:top]
+			}
+			mc.Labels = append(mc.Labels, lc)
+		}
+		sort.Slice(mc.Labels, func(i, j int) bool {
+			if mc.Labels[i].Values != mc.Labels[j].Values {
+				return mc.Labels[i].Values > mc.Labels[j].Values
+			}
+			return mc.Labels[i].Name < mc.Labels[j].Name
+		})
+
+		report.Metrics = append(report.Metrics, mc)
+	}
+	sort.SliceStable(report.Metrics, func(i, j int) bool {
+		return report.Metrics[i].Series > report.Metrics[j].Series
+	})
+
+	return report, nil
+}
+
+func (p *Prometheus) overflow() map[string]float64 {
+	overflow := make(map[string]float64)
+	if p.series != nil {
+		overflow["series_expired_total"] = readCounter(p.series.expired)
+	}
+	for _, s := range p.sinks {
+		if d, ok := s.(*StatsDSink); ok {
+			overflow["statsd_dropped_total"] += float64(d.Dropped())
+		}
+	}
+	return overflow
+}
+
+func readCounter(c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
+
+// cardinalityHandler serves Cardinality as HTML, or as JSON when asked for
+// with ?format=json or an Accept header. ?top=N sets the number of label
+// values listed.
+func (p *Prometheus) cardinalityHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		top, err := strconv.Atoi(c.Query("top"))
+		if err != nil || top <= 0 {
+			top = defaultCardinalityTop
+		}
+
+		report, err := p.Cardinality(top)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if wantsJSON(c) {
+			c.JSON(http.StatusOK, report)
+			return
+		}
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := cardinalityTemplate.Execute(c.Writer, report); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+func wantsJSON(c *gin.Context) bool {
+	if format := c.Query("format"); format != "" {
+		return format == "json"
+	}
+	return c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
+}
+
+var cardinalityTemplate = template.Must(template.New("cardinality").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Metric cardinality</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:2px 6px;text-align:left;vertical-align:top}</style>
+</head>
+<body>
+<h1>Metric cardinality</h1>
+<h2>Overflow</h2>
+<table>
+{{range $name, $value := .Overflow}}<tr><th>{{$name}}</th><td>{{$value}}</td></tr>
+{{else}}<tr><td>none</td></tr>
+{{end}}</table>
+<h2>Series</h2>
+<table>
+<tr><th>Metric</th><th>Type</th><th>Series</th><th>Labels (distinct values: top values)</th></tr>
+{{range .Metrics}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Series}}</td><td>
+{{range .Labels}}<b>{{.Name}}</b> ({{.Values}}):{{range .Top}} {{.Value}}&nbsp;({{.Series}}){{end}}<br>
+{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,96 @@
+package ginprometheus
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// chainTimerKey is the gin.Context key of the request's chainTimer.
+const chainTimerKey = "github.com/gwik/go-gin-prometheus.chain"
+
+// chainTimer holds, for every timed handler currently running, the time
+// spent in the timed handlers it called through c.Next.
+type chainTimer struct {
+	nested []time.Duration
+}
+
+// InstrumentChain wraps the middleware registered on e so far with Timed,
+// named after their functions. Routes registered afterwards inherit the
+// wrapped chain, so call it after e.Use and before adding routes.
+func (p *Prometheus) InstrumentChain(e *gin.Engine) {
+	e.Handlers = p.TimedChain(e.Handlers...)
+}
+
+// TimedChain wraps each handler with Timed, named after its function, for
+// use when registering routes:
+//
+//	r.GET("/orders", p.TimedChain(auth, rateLimit, listOrders)...)
+func (p *Prometheus) TimedChain(handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	timed := make([]gin.HandlerFunc, len(handlers))
+	for i, h := range handlers {
+		timed[i] = p.Timed(functionName(h), h)
+	}
+	return timed
+}
+
+// Timed records the time spent in h as middleware_duration_seconds. Time
+// spent in other timed handlers h runs through c.Next is not included, so
+// the durations of a chain add up to the time spent in it.
+func (p *Prometheus) Timed(name string, h gin.HandlerFunc) gin.HandlerFunc {
+	p.chainOnce.Do(p.registerChainMetrics)
+
+	return func(c *gin.Context) {
+		t, ok := c.Value(chainTimerKey).(*chainTimer)
+		if !ok {
+			t = &chainTimer{}
+			c.Set(chainTimerKey, t)
+		}
+
+		t.nested = append(t.nested, 0)
+		start := time.Now()
+
+		h(c)
+
+		total := time.Since(start)
+		n := len(t.nested) - 1
+		self := total - t.nested[n]
+		t.nested = t.nested[:n]
+		if n > 0 {
+			t.nested[n-1] += total
+		}
+
+		handler := ""
+		if o, ok := c.Value(observationKey).(*observation); ok {
+			handler = o.handler()
+		} else {
+			handler = p.handlerName(c)
+		}
+		p.series.touch(p.chainDur, handler, name)
+		p.chainDur.WithLabelValues(handler, name).Observe(self.Seconds())
+	}
+}
+
+func (p *Prometheus) registerChainMetrics() {
+	p.chainDur = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      "middleware_duration_seconds",
+			Help:      "The time spent in each middleware of the handler chain, excluding nested ones, in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"handler", "middleware"},
+	)
+	p.registerer.MustRegister(p.chainDur)
+}
+
+// functionName returns the name of h without its package path, e.g.
+// "auth.RequireUser".
+func functionName(h gin.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
@@ -0,0 +1,151 @@
+package ginprometheus
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RoundTripper instruments outbound requests made through next, which
+// defaults to http.DefaultTransport. Requests are labelled by target host
+// and operation, a name chosen by the caller such as "billing.charge".
+func (p *Prometheus) RoundTripper(operation string, next http.RoundTripper) http.RoundTripper {
+	p.clientOnce.Do(p.registerClientMetrics)
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &roundTripper{p: p, operation: operation, next: next}
+}
+
+func (p *Prometheus) registerClientMetrics() {
+	p.clientReqs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "client_requests_total",
+			Help:      "How many outbound HTTP requests were made, partitioned by host, operation, status code and HTTP method.",
+		},
+		[]string{"host", "operation", "code", "method"},
+	)
+	p.registerer.MustRegister(p.clientReqs)
+
+	p.clientDur = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      "client_request_duration_seconds",
+			Help:      "The outbound HTTP request latencies in seconds, until the response headers were read.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"host", "operation"},
+	)
+	p.registerer.MustRegister(p.clientDur)
+
+	p.clientPhases = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      "client_request_phase_duration_seconds",
+			Help:      "The time spent in the dns, connect, tls and ttfb phases of outbound HTTP requests in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"host", "operation", "phase"},
+	)
+	p.registerer.MustRegister(p.clientPhases)
+
+	p.clientInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: p.subsystem,
+			Name:      "client_in_flight_requests",
+			Help:      "How many outbound HTTP requests are waiting for their response headers.",
+		},
+		[]string{"host", "operation"},
+	)
+	p.registerer.MustRegister(p.clientInFlight)
+}
+
+type roundTripper struct {
+	p         *Prometheus
+	operation string
+	next      http.RoundTripper
+}
+
+func (rt *roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	p := rt.p
+	host := r.URL.Hostname()
+
+	inFlight := p.clientInFlight.WithLabelValues(host, rt.operation)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	t := &clientTrace{start: time.Now()}
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), t.trace()))
+
+	res, err := rt.next.RoundTrip(r)
+
+	p.clientDur.WithLabelValues(host, rt.operation).Observe(time.Since(t.start).Seconds())
+	for phase, d := range t.phases() {
+		p.clientPhases.WithLabelValues(host, rt.operation, phase).Observe(d.Seconds())
+	}
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	p.clientReqs.WithLabelValues(host, rt.operation, code, strings.ToLower(r.Method)).Inc()
+
+	return res, err
+}
+
+// clientTrace collects phase timings. Its hooks may run on the transport's
+// goroutines, dialing happens concurrently for instance.
+type clientTrace struct {
+	start time.Time
+
+	mu                           sync.Mutex
+	dnsStart, dnsDone            time.Time
+	connectStart, connectDone    time.Time
+	tlsStart, tlsDone, firstByte time.Time
+}
+
+func (t *clientTrace) set(at *time.Time, onlyFirst bool) {
+	t.mu.Lock()
+	if !onlyFirst || at.IsZero() {
+		*at = time.Now()
+	}
+	t.mu.Unlock()
+}
+
+func (t *clientTrace) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.set(&t.dnsStart, true) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone, false) },
+		ConnectStart:         func(string, string) { t.set(&t.connectStart, true) },
+		ConnectDone:          func(string, string, error) { t.set(&t.connectDone, false) },
+		TLSHandshakeStart:    func() { t.set(&t.tlsStart, true) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.set(&t.tlsDone, false) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte, true) },
+	}
+}
+
+// phases returns the phases that completed. Reused connections have no
+// dns, connect or tls phase.
+func (t *clientTrace) phases() map[string]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	phases := make(map[string]time.Duration, 4)
+	add := func(phase string, start, end time.Time) {
+		if !start.IsZero() && !end.IsZero() {
+			phases[phase] = end.Sub(start)
+		}
+	}
+	add("dns", t.dnsStart, t.dnsDone)
+	add("connect", t.connectStart, t.connectDone)
+	add("tls", t.tlsStart, t.tlsDone)
+	add("ttfb", t.start, t.firstByte)
+	return phases
+}
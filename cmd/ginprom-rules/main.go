@@ -0,0 +1,70 @@
+// Command ginprom-rules writes a Prometheus rules file for the metrics of
+// go-gin-prometheus.
+//
+//	ginprom-rules -subsystem gin -selector 'job="api"' -slo availability=0.999 -o rules.yml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gwik/go-gin-prometheus"
+)
+
+type sloFlags []ginprometheus.RuleSLO
+
+func (s *sloFlags) String() string {
+	var parts []string
+	for _, slo := range *s {
+		parts = append(parts, fmt.Sprintf("%s=%v", slo.Name, slo.Objective))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *sloFlags) Set(v string) error {
+	name, objective, ok := strings.Cut(v, "=")
+	if !ok {
+		*s = append(*s, ginprometheus.RuleSLO{Name: v})
+		return nil
+	}
+	f, err := strconv.ParseFloat(objective, 64)
+	if err != nil || f <= 0 || f >= 1 {
+		return fmt.Errorf("objective of %q must be between 0 and 1", name)
+	}
+	*s = append(*s, ginprometheus.RuleSLO{Name: name, Objective: f})
+	return nil
+}
+
+func main() {
+	var cfg ginprometheus.RulesConfig
+	var slos sloFlags
+	flag.StringVar(&cfg.Namespace, "namespace", "", "metric namespace")
+	flag.StringVar(&cfg.Subsystem, "subsystem", "", "metric subsystem, as passed to NewPrometheus")
+	flag.StringVar(&cfg.Selector, "selector", "", `label matchers added to every query, e.g. job="api"`)
+	flag.BoolVar(&cfg.NativeHistograms, "native", false, "the latency histogram is native, add p99 rules")
+	flag.Var(&slos, "slo", "SLO `name=objective`, e.g. availability=0.999; repeatable")
+	out := flag.String("o", "", "output file, stdout if empty")
+	flag.Parse()
+	cfg.SLOs = slos
+
+	if *out == "" {
+		if err := ginprometheus.WriteRules(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ginprometheus.WriteRules(f, cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+package ginprometheus
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InstrumentServer hooks srv's ConnState callback to record connection
+// churn, keep-alive reuse and idle connections. Any existing ConnState
+// callback is still called. Use it with srv.ListenAndServe in place of
+// gin's Run:
+//
+//	srv := &http.Server{Addr: ":8080", Handler: r}
+//	p.InstrumentServer(srv)
+//	srv.ListenAndServe()
+func (p *Prometheus) InstrumentServer(srv *http.Server) {
+	p.connOnce.Do(p.registerConnMetrics)
+
+	next := srv.ConnState
+	srv.ConnState = func(conn net.Conn, state http.ConnState) {
+		p.conns.track(conn, state)
+		if next != nil {
+			next(conn, state)
+		}
+	}
+}
+
+func (p *Prometheus) registerConnMetrics() {
+	p.openConns = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: p.subsystem,
+			Name:      "open_connections",
+			Help:      "How many client connections are open.",
+		},
+	)
+	p.registerer.MustRegister(p.openConns)
+
+	p.connStates = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: p.subsystem,
+			Name:      "connections",
+			Help:      "How many client connections are open, partitioned by active or idle state.",
+		},
+		[]string{"state"},
+	)
+	p.registerer.MustRegister(p.connStates)
+
+	p.connsNew = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "connections_total",
+			Help:      "How many client connections were accepted.",
+		},
+	)
+	p.registerer.MustRegister(p.connsNew)
+
+	p.connReuse = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "connection_reuses_total",
+			Help:      "How many requests were served on an idle keep-alive connection.",
+		},
+	)
+	p.registerer.MustRegister(p.connReuse)
+
+	p.connDur = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      "connection_duration_seconds",
+			Help:      "The lifetime of client connections in seconds.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+	)
+	p.registerer.MustRegister(p.connDur)
+
+	p.conns = &connTracker{p: p, conns: make(map[net.Conn]*connInfo)}
+}
+
+type connInfo struct {
+	state http.ConnState
+	start time.Time
+}
+
+type connTracker struct {
+	p *Prometheus
+
+	mu    sync.Mutex
+	conns map[net.Conn]*connInfo
+}
+
+func (t *connTracker) track(conn net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, ok := t.conns[conn]
+	if state == http.StateNew {
+		t.conns[conn] = &connInfo{state: state, start: time.Now()}
+		t.p.openConns.Inc()
+		t.p.connsNew.Inc()
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if info.state == http.StateActive || info.state == http.StateIdle {
+		t.p.connStates.WithLabelValues(connStateLabel(info.state)).Dec()
+	}
+
+	switch state {
+	case http.StateActive, http.StateIdle:
+		if state == http.StateActive && info.state == http.StateIdle {
+			t.p.connReuse.Inc()
+		}
+		t.p.connStates.WithLabelValues(connStateLabel(state)).Inc()
+		info.state = state
+	case http.StateHijacked, http.StateClosed:
+		t.p.openConns.Dec()
+		t.p.connDur.Observe(time.Since(info.start).Seconds())
+		delete(t.conns, conn)
+	}
+}
+
+func connStateLabel(state http.ConnState) string {
+	if state == http.StateActive {
+		return "active"
+	}
+	return "idle"
+}
@@ -0,0 +1,95 @@
+package ginprometheus
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// observationKey is the gin.Context key under which the middleware stores
+// the current request's observation.
+const observationKey = "github.com/gwik/go-gin-prometheus.observation"
+
+// customMetrics holds the metrics created by Counter and Observe, keyed by
+// name. They are registered on first use.
+type customMetrics struct {
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+// Counter returns the counter name for the current request, labelled with
+// its method and handler, e.g. Counter(c, "orders_created_total").Inc().
+// The metric is registered with the instance's registry, under its
+// subsystem, the first time it is used. Outside the middleware the counter
+// is not registered anywhere.
+func Counter(c *gin.Context, name string) prometheus.Counter {
+	o, ok := c.Value(observationKey).(*observation)
+	if !ok {
+		return prometheus.NewCounter(prometheus.CounterOpts{Name: name})
+	}
+	vec, method, handler := o.p.custom.counter(o.p, name), strings.ToLower(o.r.Method), o.handler()
+	o.p.series.touch(vec, method, handler)
+	return vec.WithLabelValues(method, handler)
+}
+
+// Observe records v in the histogram name for the current request,
+// labelled like Counter. It uses the default buckets.
+func Observe(c *gin.Context, name string, v float64) {
+	o, ok := c.Value(observationKey).(*observation)
+	if !ok {
+		return
+	}
+	vec, method, handler := o.p.custom.histogram(o.p, name), strings.ToLower(o.r.Method), o.handler()
+	o.p.series.touch(vec, method, handler)
+	vec.WithLabelValues(method, handler).Observe(v)
+}
+
+func (m *customMetrics) counter(p *Prometheus, name string) *prometheus.CounterVec {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if v, ok := m.counters[name]; ok {
+		return v
+	}
+	v := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      name,
+			Help:      "Custom counter recorded by handlers, partitioned by HTTP method and handler.",
+		},
+		[]string{"method", "handler"},
+	)
+	p.registerer.MustRegister(v)
+	if m.counters == nil {
+		m.counters = make(map[string]*prometheus.CounterVec)
+	}
+	m.counters[name] = v
+	return v
+}
+
+func (m *customMetrics) histogram(p *Prometheus, name string) *prometheus.HistogramVec {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if v, ok := m.histograms[name]; ok {
+		return v
+	}
+	v := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      name,
+			Help:      "Custom histogram recorded by handlers, partitioned by HTTP method and handler.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"method", "handler"},
+	)
+	p.registerer.MustRegister(v)
+	if m.histograms == nil {
+		m.histograms = make(map[string]*prometheus.HistogramVec)
+	}
+	m.histograms[name] = v
+	return v
+}
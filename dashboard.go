@@ -0,0 +1,76 @@
+package ginprometheus
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultDashboardWindow = 5 * time.Minute
+
+type dashboardRow struct {
+	Handler string
+	RouteStats
+}
+
+type dashboardPage struct {
+	Window time.Duration
+	Rows   []dashboardRow
+}
+
+// dashboardHandler renders the rolling statistics over the window given
+// by the window query parameter, five minutes by default. The page reloads
+// itself every five seconds.
+func (p *Prometheus) dashboardHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		window, err := time.ParseDuration(c.Query("window"))
+		if err != nil || window <= 0 {
+			window = defaultDashboardWindow
+		}
+
+		page := dashboardPage{Window: window}
+		for handler, rs := range p.RouteStats(window) {
+			page.Rows = append(page.Rows, dashboardRow{Handler: handler, RouteStats: rs})
+		}
+		sort.Slice(page.Rows, func(i, j int) bool {
+			return page.Rows[i].Handler < page.Rows[j].Handler
+		})
+
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := dashboardTemplate.Execute(c.Writer, page); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"percent": formatPercent,
+	"ms":      formatMillis,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta http-equiv="refresh" content="5"><title>Routes</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:2px 8px}td{text-align:right}td:first-child{text-align:left}.bad{color:#c00}</style>
+</head>
+<body>
+<h1>Routes over the last {{.Window}}</h1>
+<table>
+<tr><th>Handler</th><th>Requests</th><th>Req/s</th><th>Errors</th><th>p50 ms</th><th>p90 ms</th><th>p99 ms</th></tr>
+{{range .Rows}}<tr><td>{{.Handler}}</td><td>{{.Requests}}</td><td>{{printf "%.2f" .RequestsPerSecond}}</td><td{{if .ErrorRatio}} class="bad"{{end}}>{{percent .ErrorRatio}}</td><td>{{ms .P50}}</td><td>{{ms .P90}}</td><td>{{ms .P99}}</td></tr>
+{{else}}<tr><td colspan="7">No requests yet.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func formatPercent(f float64) string {
+	return strconv.FormatFloat(f*100, 'f', 2, 64) + "%"
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64)
+}
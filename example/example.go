@@ -10,6 +10,7 @@ func main() {
 	r := gin.New()
 
 	p := ginprometheus.NewPrometheus("gin")
+	p.HandlerNameFunc = ginprometheus.HandlerNameRoute
 	p.Use(r)
 
 	r.GET("/", func(c *gin.Context) {
@@ -0,0 +1,114 @@
+package ginprometheus
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WithSeriesTTL deletes the series of the per-handler metrics, such as
+// requests_total, once their label combination has not been observed for
+// ttl. A background goroutine sweeps every ttl/4 until Close is called.
+// A ttl of zero or less disables expiry.
+// Counters of an expired series restart from zero if it reappears, which
+// rate() treats as a counter reset.
+func WithSeriesTTL(ttl time.Duration) Option {
+	return func(p *Prometheus) {
+		if ttl <= 0 {
+			return
+		}
+		p.series = &seriesTracker{
+			ttl:  ttl,
+			seen: make(map[seriesKey]time.Time),
+			stop: make(chan struct{}),
+		}
+	}
+}
+
+// Close stops the background work started by the options, such as the
+// WithSeriesTTL sweeper.
+func (p *Prometheus) Close() {
+	if p.series != nil {
+		p.series.stopOnce.Do(func() { close(p.series.stop) })
+	}
+}
+
+// labelDeleter is implemented by every metric vector.
+type labelDeleter interface {
+	DeleteLabelValues(lvs ...string) bool
+}
+
+type seriesKey struct {
+	vec    labelDeleter
+	labels string
+}
+
+// seriesTracker records when each series was last observed. Observers call
+// touch before updating a series: the sweeper then either sees the fresh
+// timestamp or deleted the series before it was touched, in which case
+// the update recreates it.
+type seriesTracker struct {
+	ttl     time.Duration
+	expired prometheus.Counter
+
+	mu   sync.Mutex
+	seen map[seriesKey]time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// touch is a no-op on a nil tracker, when WithSeriesTTL is not used.
+func (t *seriesTracker) touch(vec labelDeleter, lvs ...string) {
+	if t == nil {
+		return
+	}
+	key := seriesKey{vec: vec, labels: strings.Join(lvs, "\xff")}
+	now := time.Now()
+
+	t.mu.Lock()
+	t.seen[key] = now
+	t.mu.Unlock()
+}
+
+func (t *seriesTracker) run() {
+	ticker := time.NewTicker(t.ttl / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			t.sweep(now)
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+func (t *seriesTracker) sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, seen := range t.seen {
+		if now.Sub(seen) < t.ttl {
+			continue
+		}
+		key.vec.DeleteLabelValues(strings.Split(key.labels, "\xff")...)
+		delete(t.seen, key)
+		t.expired.Inc()
+	}
+}
+
+func (p *Prometheus) startSeriesExpiry() {
+	p.series.expired = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "series_expired_total",
+			Help:      "How many series were deleted after not being observed for the series TTL.",
+		},
+	)
+	p.registerer.MustRegister(p.series.expired)
+	go p.series.run()
+}
@@ -0,0 +1,49 @@
+package ginprometheus
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlerNameKey is the gin.Context key written by SetHandlerName.
+const handlerNameKey = "github.com/gwik/go-gin-prometheus.handler"
+
+// HandlerNameFunc returns the value of the handler label for a request.
+type HandlerNameFunc func(c *gin.Context) string
+
+// HandlerNameShort trims the package path and the "Handle" prefix from the
+// handler's function name. It is the default.
+func HandlerNameShort(c *gin.Context) string {
+	splitName := strings.Split(c.HandlerName(), ".")
+	return strings.TrimPrefix(splitName[len(splitName)-1], "Handle")
+}
+
+// HandlerNameFull uses the handler's fully qualified function name.
+func HandlerNameFull(c *gin.Context) string {
+	return c.HandlerName()
+}
+
+// HandlerNameRoute uses the matched route template, e.g. "/users/:id".
+// Unmatched requests fall back to HandlerNameShort.
+func HandlerNameRoute(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return HandlerNameShort(c)
+}
+
+// HandlerNameFromContext uses the name a handler stored with SetHandlerName,
+// falling back to HandlerNameShort.
+func HandlerNameFromContext(c *gin.Context) string {
+	if name := c.GetString(handlerNameKey); name != "" {
+		return name
+	}
+	return HandlerNameShort(c)
+}
+
+// SetHandlerName stores the handler label for HandlerNameFromContext. It is
+// the only supported way to set that value.
+func SetHandlerName(c *gin.Context, name string) {
+	c.Set(handlerNameKey, name)
+}
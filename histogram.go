@@ -0,0 +1,123 @@
+package ginprometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NativeHistogramOpts configures the native histograms created by
+// WithNativeHistograms. Zero values use the defaults below.
+type NativeHistogramOpts struct {
+	// BucketFactor bounds the growth from one bucket to the next.
+	// Defaults to 1.1.
+	BucketFactor float64
+
+	// MaxBucketNumber caps the number of buckets, the resolution is
+	// reduced when it is exceeded. Defaults to 160.
+	MaxBucketNumber uint32
+
+	// MinResetDuration is the minimum time between resets of a histogram
+	// that hit MaxBucketNumber. Defaults to one hour.
+	MinResetDuration time.Duration
+
+	// Classic also serves the classic buckets, prometheus.DefBuckets for
+	// durations and powers of ten for sizes, for scrapers that do not
+	// negotiate native histograms.
+	Classic bool
+}
+
+// WithNativeHistograms creates request_duration_seconds,
+// request_size_bytes and response_size_bytes as native histograms instead
+// of summaries. Native histograms are only exposed in the protobuf format,
+// which the metrics endpoint serves to scrapers asking for it.
+func WithNativeHistograms(opts NativeHistogramOpts) Option {
+	return func(p *Prometheus) {
+		if opts.BucketFactor <= 1 {
+			opts.BucketFactor = 1.1
+		}
+		if opts.MaxBucketNumber == 0 {
+			opts.MaxBucketNumber = 160
+		}
+		if opts.MinResetDuration == 0 {
+			opts.MinResetDuration = time.Hour
+		}
+		p.native = &opts
+	}
+}
+
+// DefaultObjectives are the quantiles computed by the summaries: p50, p90
+// and p99.
+var DefaultObjectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+
+// SummaryConfig tunes one of the request_duration_seconds,
+// request_size_bytes and response_size_bytes summaries. Zero values use the
+// client library's defaults, except Objectives.
+type SummaryConfig struct {
+	// Objectives maps quantiles to their allowed error. Defaults to
+	// DefaultObjectives, an empty map computes no quantiles.
+	Objectives map[float64]float64
+
+	// MaxAge is how long observations count towards the quantiles.
+	MaxAge time.Duration
+
+	// AgeBuckets is how many buckets MaxAge is split into.
+	AgeBuckets uint32
+
+	// BufCap is the size of the buffer observations are batched in.
+	BufCap uint32
+}
+
+// WithSummaryConfig tunes the summary called name, e.g.
+// "request_duration_seconds". It has no effect with WithNativeHistograms.
+func WithSummaryConfig(name string, cfg SummaryConfig) Option {
+	return func(p *Prometheus) {
+		if p.summaries == nil {
+			p.summaries = make(map[string]SummaryConfig)
+		}
+		p.summaries[name] = cfg
+	}
+}
+
+// observerMetric is a Summary, or a Histogram with WithNativeHistograms.
+type observerMetric interface {
+	prometheus.Observer
+	prometheus.Collector
+}
+
+func (p *Prometheus) newObserverMetric(name, help string, classic []float64) observerMetric {
+	if p.native == nil {
+		cfg := p.summaries[name]
+		if cfg.Objectives == nil {
+			cfg.Objectives = DefaultObjectives
+		}
+		return prometheus.NewSummary(
+			prometheus.SummaryOpts{
+				Subsystem:  p.subsystem,
+				Name:       name,
+				Help:       help,
+				Objectives: cfg.Objectives,
+				MaxAge:     cfg.MaxAge,
+				AgeBuckets: cfg.AgeBuckets,
+				BufCap:     cfg.BufCap,
+			},
+		)
+	}
+
+	// An empty, non-nil slice disables the classic buckets.
+	buckets := []float64{}
+	if p.native.Classic {
+		buckets = classic
+	}
+	return prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem:                       p.subsystem,
+			Name:                            name,
+			Help:                            help,
+			Buckets:                         buckets,
+			NativeHistogramBucketFactor:     p.native.BucketFactor,
+			NativeHistogramMaxBucketNumber:  p.native.MaxBucketNumber,
+			NativeHistogramMinResetDuration: p.native.MinResetDuration,
+		},
+	)
+}
@@ -0,0 +1,139 @@
+package ginprometheus
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// observation is the state of one instrumented request, shared by the gin
+// and net/http adapters. The adapter reports writes, flushes and hijacks
+// as they happen and calls finish once the handler returned.
+type observation struct {
+	p      *Prometheus
+	r      *http.Request
+	header http.Header
+
+	// handler computes the handler label. It is called lazily because
+	// routers usually only know the route once the handler ran.
+	handler func() string
+
+	start     time.Time
+	reqSz     chan int
+	firstByte time.Time
+	stream    *stream
+	hijacked  bool
+
+	// mu guards phases, which handlers may end from other goroutines.
+	mu     sync.Mutex
+	phases []phaseTiming
+}
+
+func (p *Prometheus) begin(r *http.Request, header http.Header, handler func() string) *observation {
+	o := &observation{
+		p:       p,
+		r:       r,
+		header:  header,
+		handler: handler,
+		start:   time.Now(),
+		reqSz:   make(chan int),
+	}
+
+	urlLen := 0
+	if r.URL != nil {
+		urlLen = len(r.URL.String())
+	}
+	go computeApproximateRequestSize(r, o.reqSz, urlLen)
+
+	if p.queueDur != nil {
+		p.observeQueueTime(r, o.start)
+	}
+
+	return o
+}
+
+// markFirstByte must be called right before the headers are written.
+func (o *observation) markFirstByte() {
+	if o.firstByte.IsZero() {
+		o.writeServerTiming()
+		o.firstByte = time.Now()
+	}
+}
+
+func (o *observation) flushed() {
+	o.markFirstByte()
+	if o.stream == nil {
+		o.stream = o.p.startStream(streamKindFlushed, o.handler())
+	}
+	o.stream.message()
+}
+
+func (o *observation) hijack(conn net.Conn) net.Conn {
+	o.markFirstByte()
+	if o.stream != nil {
+		o.stream.end()
+	}
+	o.stream = o.p.startStream(streamKindHijacked, o.handler())
+	o.hijacked = true
+	return &streamConn{Conn: conn, stream: o.stream}
+}
+
+// finish records the request once the handler returned. aborted reports
+// whether a middleware stopped the chain, for routers that have the notion.
+func (o *observation) finish(code, size int, aborted bool) {
+	p := o.p
+
+	outcome := requestOutcome(o.r, aborted)
+	if outcome == outcomeCancelled && p.CancelledStatusCode != 0 {
+		code = p.CancelledStatusCode
+	}
+	method := strings.ToLower(o.r.Method)
+	elapsed := time.Since(o.start)
+
+	handlerName := o.handler()
+
+	// Bodyless responses get their headers written once the handler
+	// returns, so its end is the best estimate.
+	o.markFirstByte()
+	firstByte := o.firstByte
+
+	// Streams are excluded from the latency metrics, their duration is
+	// recorded when they end instead.
+	if o.stream == nil {
+		p.observeSLOs(handlerName, code, elapsed)
+		if p.apdex != nil {
+			p.apdex.observe(handlerName, code, elapsed)
+		}
+	} else if !o.hijacked {
+		o.stream.bytes("out", size)
+		o.stream.end()
+	}
+	p.series.touch(p.ttfb, handlerName)
+	p.ttfb.WithLabelValues(handlerName).Observe(firstByte.Sub(o.start).Seconds())
+	p.series.touch(p.reqOutcome, outcome, method, handlerName)
+	p.reqOutcome.WithLabelValues(outcome, method, handlerName).Inc()
+	if p.reqProto != nil {
+		p.reqProto.WithLabelValues(requestProtocol(o.r)).Inc()
+	}
+
+	if p.stats != nil {
+		p.stats.observe(time.Now(), handlerName, code, elapsed, o.stream != nil)
+	}
+
+	req := &Request{
+		Context:         o.r.Context(),
+		Method:          o.r.Method,
+		Handler:         handlerName,
+		Code:            code,
+		Duration:        elapsed,
+		Stream:          o.stream != nil,
+		RequestSize:     <-o.reqSz,
+		RequestBodySize: o.r.ContentLength,
+		ResponseSize:    size,
+	}
+	for _, s := range p.sinks {
+		s.Record(req)
+	}
+}
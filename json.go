@@ -0,0 +1,148 @@
+package ginprometheus
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// JSONFamily is a gathered metric family rendered by the JSON endpoint.
+type JSONFamily struct {
+	Name    string       `json:"name"`
+	Type    string       `json:"type"`
+	Help    string       `json:"help"`
+	Metrics []JSONMetric `json:"metrics"`
+}
+
+// JSONMetric is one label set of a family. Counters, gauges and untyped
+// metrics have a Value, summaries and histograms a Count, a Sum and their
+// Quantiles or cumulative Buckets.
+type JSONMetric struct {
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     *JSONFloat        `json:"value,omitempty"`
+	Count     *uint64           `json:"count,omitempty"`
+	Sum       *JSONFloat        `json:"sum,omitempty"`
+	Quantiles []JSONQuantile    `json:"quantiles,omitempty"`
+	Buckets   []JSONBucket      `json:"buckets,omitempty"`
+}
+
+// JSONQuantile is one objective of a summary.
+type JSONQuantile struct {
+	Quantile float64   `json:"quantile"`
+	Value    JSONFloat `json:"value"`
+}
+
+// JSONBucket is one cumulative histogram bucket, the last one is +Inf.
+type JSONBucket struct {
+	UpperBound JSONFloat `json:"upper_bound"`
+	Count      uint64    `json:"count"`
+}
+
+// JSONFloat encodes NaN and infinities, which JSON numbers cannot hold, as
+// the strings "NaN", "+Inf" and "-Inf" like the text exposition format.
+type JSONFloat float64
+
+func (f JSONFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+}
+
+func (f *JSONFloat) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseFloat(strings.Trim(string(b), `"`), 64)
+	if err != nil {
+		return err
+	}
+	*f = JSONFloat(v)
+	return nil
+}
+
+func jsonFloat(v float64) *JSONFloat {
+	f := JSONFloat(v)
+	return &f
+}
+
+// jsonHandler gathers the instance's registry and renders it as JSON.
+// Families are filtered by the repeatable name parameter, matching names
+// exactly, and the prefix parameter.
+func (p *Prometheus) jsonHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mfs, err := p.gatherer.Gather()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		names := make(map[string]bool)
+		for _, name := range c.QueryArray("name") {
+			names[name] = true
+		}
+		prefix := c.Query("prefix")
+
+		families := []JSONFamily{}
+		for _, mf := range mfs {
+			if len(names) > 0 && !names[mf.GetName()] {
+				continue
+			}
+			if !strings.HasPrefix(mf.GetName(), prefix) {
+				continue
+			}
+			families = append(families, newJSONFamily(mf))
+		}
+		c.JSON(http.StatusOK, families)
+	}
+}
+
+func newJSONFamily(mf *dto.MetricFamily) JSONFamily {
+	f := JSONFamily{
+		Name: mf.GetName(),
+		Type: strings.ToLower(mf.GetType().String()),
+		Help: mf.GetHelp(),
+	}
+	for _, m := range mf.GetMetric() {
+		jm := JSONMetric{}
+		if len(m.GetLabel()) > 0 {
+			jm.Labels = make(map[string]string, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				jm.Labels[lp.GetName()] = lp.GetValue()
+			}
+		}
+
+		switch {
+		case m.Counter != nil:
+			jm.Value = jsonFloat(m.GetCounter().GetValue())
+		case m.Gauge != nil:
+			jm.Value = jsonFloat(m.GetGauge().GetValue())
+		case m.Untyped != nil:
+			jm.Value = jsonFloat(m.GetUntyped().GetValue())
+		case m.Summary != nil:
+			s := m.GetSummary()
+			count := s.GetSampleCount()
+			jm.Count, jm.Sum = &count, jsonFloat(s.GetSampleSum())
+			for _, q := range s.GetQuantile() {
+				jm.Quantiles = append(jm.Quantiles, JSONQuantile{Quantile: q.GetQuantile(), Value: JSONFloat(q.GetValue())})
+			}
+		case m.Histogram != nil:
+			h := m.GetHistogram()
+			count := h.GetSampleCount()
+			jm.Count, jm.Sum = &count, jsonFloat(h.GetSampleSum())
+			for _, b := range h.GetBucket() {
+				jm.Buckets = append(jm.Buckets, JSONBucket{UpperBound: JSONFloat(b.GetUpperBound()), Count: b.GetCumulativeCount()})
+			}
+			jm.Buckets = append(jm.Buckets, JSONBucket{UpperBound: JSONFloat(math.Inf(1)), Count: count})
+		}
+		f.Metrics = append(f.Metrics, jm)
+	}
+	return f
+}
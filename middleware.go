@@ -2,35 +2,127 @@ package ginprometheus
 
 import (
 	"net/http"
-	"strconv"
-	"strings"
-	"time"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var defaultMetricPath = "/metrics"
 
 type Prometheus struct {
-	reqCnt               *prometheus.CounterVec
-	reqDur, reqSz, resSz prometheus.Summary
+	reqCnt, reqOutcome   *prometheus.CounterVec
+	reqDur, reqSz, resSz observerMetric
+	ttfb, reqPhases      *prometheus.HistogramVec
+
+	activeStreams           *prometheus.GaugeVec
+	streamDur               *prometheus.HistogramVec
+	streamMsgs, streamBytes *prometheus.CounterVec
+
+	reqProto *prometheus.CounterVec
+
+	queueDur     prometheus.Histogram
+	queueInvalid *prometheus.CounterVec
+
+	slos             []SLO
+	sloReqs, sloGood *prometheus.CounterVec
+
+	apdex *apdexTracker
+
+	series *seriesTracker
+
+	stats *rollingStats
+	shed  *loadShedder
+
+	native    *NativeHistogramOpts
+	summaries map[string]SummaryConfig
+
+	clientOnce              sync.Once
+	clientReqs              *prometheus.CounterVec
+	clientDur, clientPhases *prometheus.HistogramVec
+	clientInFlight          *prometheus.GaugeVec
+
+	custom customMetrics
+
+	chainOnce sync.Once
+	chainDur  *prometheus.HistogramVec
+
+	sinks []Sink
+
+	connOnce            sync.Once
+	conns               *connTracker
+	openConns           prometheus.Gauge
+	connStates          *prometheus.GaugeVec
+	connsNew, connReuse prometheus.Counter
+	connDur             prometheus.Histogram
+
+	subsystem  string
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
 
 	MetricsPath string
+
+	// CardinalityPath, when set, is where Use mounts a debug page listing
+	// the series count of every metric and its top label values.
+	CardinalityPath string
+
+	// JSONPath, when set, is where Use mounts the metrics rendered as JSON.
+	JSONPath string
+
+	// DashboardPath, when set, is where Use mounts an HTML page of live
+	// per-handler request rates, error rates and latency quantiles,
+	// computed in process. Setting it keeps rolling statistics.
+	DashboardPath string
+
+	// HandlerNameFunc computes the handler label. Defaults to HandlerNameShort
+	// when nil. Strategies such as HandlerNameRoute yield one series per
+	// route template, which can be far more than the short names.
+	HandlerNameFunc HandlerNameFunc
+
+	// ServerTiming adds the phases timed with StartPhase to the response
+	// as a Server-Timing header, for browser developer tools.
+	ServerTiming bool
+
+	// CancelledStatusCode, when non-zero, replaces the status code recorded
+	// for requests whose client went away, e.g. StatusClientClosedRequest.
+	CancelledStatusCode int
 }
 
-func NewPrometheus(subsystem string) *Prometheus {
+// Option configures a Prometheus instance at construction time.
+type Option func(*Prometheus)
+
+// WithRegistry registers the metrics with reg, and serves them from it on
+// MetricsPath, instead of using the global default registry.
+func WithRegistry(reg *prometheus.Registry) Option {
+	return func(p *Prometheus) {
+		p.registerer = reg
+		p.gatherer = reg
+	}
+}
+
+func NewPrometheus(subsystem string, opts ...Option) *Prometheus {
 	p := &Prometheus{
-		MetricsPath: defaultMetricPath,
+		MetricsPath:     defaultMetricPath,
+		HandlerNameFunc: HandlerNameShort,
+		subsystem:       subsystem,
+		registerer:      prometheus.DefaultRegisterer,
+		gatherer:        prometheus.DefaultGatherer,
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
 
 	p.registerMetrics(subsystem)
+	if p.series != nil {
+		p.startSeriesExpiry()
+	}
 
 	return p
 }
 
-func Middleware(subsystem string) gin.HandlerFunc {
-	return NewPrometheus(subsystem).handlerFunc()
+func Middleware(subsystem string, opts ...Option) gin.HandlerFunc {
+	return NewPrometheus(subsystem, opts...).handlerFunc()
 }
 
 func (p *Prometheus) registerMetrics(subsystem string) {
@@ -42,39 +134,116 @@ func (p *Prometheus) registerMetrics(subsystem string) {
 		},
 		[]string{"code", "method", "handler"},
 	)
-	prometheus.MustRegister(p.reqCnt)
+	p.registerer.MustRegister(p.reqCnt)
+
+	p.reqOutcome = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "request_outcomes_total",
+			Help:      "How many HTTP requests completed, were aborted by a handler or were cancelled by the client.",
+		},
+		[]string{"outcome", "method", "handler"},
+	)
+	p.registerer.MustRegister(p.reqOutcome)
+
+	sizeBuckets := prometheus.ExponentialBuckets(100, 10, 6)
+
+	p.reqDur = p.newObserverMetric("request_duration_seconds", "The HTTP request latencies in seconds.", prometheus.DefBuckets)
+	p.registerer.MustRegister(p.reqDur)
+
+	p.reqSz = p.newObserverMetric("request_size_bytes", "The HTTP request sizes in bytes.", sizeBuckets)
+	p.registerer.MustRegister(p.reqSz)
+
+	p.resSz = p.newObserverMetric("response_size_bytes", "The HTTP response sizes in bytes.", sizeBuckets)
+	p.registerer.MustRegister(p.resSz)
+
+	p.ttfb = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subsystem,
+			Name:      "time_to_first_byte_seconds",
+			Help:      "The time until the HTTP response headers or first body byte were written, in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"handler"},
+	)
+	p.registerer.MustRegister(p.ttfb)
+
+	p.reqPhases = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subsystem,
+			Name:      "request_phase_duration_seconds",
+			Help:      "The time spent in phases of HTTP requests timed by handlers, in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"handler", "phase"},
+	)
+	p.registerer.MustRegister(p.reqPhases)
+
+	p.activeStreams = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "active_streams",
+			Help:      "How many streaming responses and hijacked connections are open, partitioned by kind and handler.",
+		},
+		[]string{"kind", "handler"},
+	)
+	p.registerer.MustRegister(p.activeStreams)
 
-	p.reqDur = prometheus.NewSummary(
-		prometheus.SummaryOpts{
+	p.streamDur = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
 			Subsystem: subsystem,
-			Name:      "request_duration_seconds",
-			Help:      "The HTTP request latencies in seconds.",
+			Name:      "stream_duration_seconds",
+			Help:      "The lifetime of streaming responses and hijacked connections in seconds.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10),
 		},
+		[]string{"kind", "handler"},
 	)
-	prometheus.MustRegister(p.reqDur)
+	p.registerer.MustRegister(p.streamDur)
 
-	p.reqSz = prometheus.NewSummary(
-		prometheus.SummaryOpts{
+	p.streamMsgs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
 			Subsystem: subsystem,
-			Name:      "request_size_bytes",
-			Help:      "The HTTP request sizes in bytes.",
+			Name:      "stream_messages_total",
+			Help:      "How many messages were sent on streams, counted as flushes or connection writes.",
 		},
+		[]string{"kind", "handler"},
 	)
-	prometheus.MustRegister(p.reqSz)
+	p.registerer.MustRegister(p.streamMsgs)
 
-	p.resSz = prometheus.NewSummary(
-		prometheus.SummaryOpts{
+	p.streamBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
 			Subsystem: subsystem,
-			Name:      "response_size_bytes",
-			Help:      "The HTTP response sizes in bytes.",
+			Name:      "stream_bytes_total",
+			Help:      "How many bytes were transferred on streams, partitioned by direction.",
 		},
+		[]string{"kind", "handler", "direction"},
 	)
-	prometheus.MustRegister(p.resSz)
+	p.registerer.MustRegister(p.streamBytes)
+
+	p.sinks = append(p.sinks, &promSink{
+		series: p.series,
+		reqCnt: p.reqCnt,
+		reqDur: p.reqDur,
+		reqSz:  p.reqSz,
+		resSz:  p.resSz,
+	})
 }
 
 func (p *Prometheus) Use(e *gin.Engine) {
+	if p.DashboardPath != "" {
+		p.EnableRollingStats()
+		e.GET(p.DashboardPath, p.dashboardHandler())
+	}
 	e.Use(p.handlerFunc())
-	e.GET(p.MetricsPath, prometheusHandler())
+	if p.gatherer != nil {
+		e.GET(p.MetricsPath, p.prometheusHandler())
+		if p.CardinalityPath != "" {
+			e.GET(p.CardinalityPath, p.cardinalityHandler())
+		}
+		if p.JSONPath != "" {
+			e.GET(p.JSONPath, p.jsonHandler())
+		}
+	}
 }
 
 func (p *Prometheus) handlerFunc() gin.HandlerFunc {
@@ -84,34 +253,30 @@ func (p *Prometheus) handlerFunc() gin.HandlerFunc {
 			return
 		}
 
-		start := time.Now()
+		o := p.begin(c.Request, c.Writer.Header(), func() string { return p.handlerName(c) })
+		c.Writer = &responseWriter{ResponseWriter: c.Writer, o: o}
+		c.Set(observationKey, o)
 
-		reqSz := make(chan int)
-		urlLen := 0
-		if c.Request.URL != nil {
-			urlLen = len(c.Request.URL.String())
+		if p.shed == nil {
+			c.Next()
+		} else if p.admit(c) {
+			defer p.release()
+			c.Next()
 		}
-		go computeApproximateRequestSize(c.Request, reqSz, urlLen)
-
-		c.Next()
 
-		status := strconv.Itoa(c.Writer.Status())
-		method := strings.ToLower(c.Request.Method)
-		elapsed := time.Since(start).Seconds()
-		resSz := float64(c.Writer.Size())
-
-		splitName := strings.Split(c.HandlerName(), ".")
-		handlerName := strings.TrimPrefix(splitName[len(splitName)-1], "Handle")
+		o.finish(c.Writer.Status(), c.Writer.Size(), c.IsAborted())
+	}
+}
 
-		p.reqDur.Observe(elapsed)
-		p.reqCnt.WithLabelValues(status, method, handlerName).Inc()
-		p.reqSz.Observe(float64(<-reqSz))
-		p.resSz.Observe(resSz)
+func (p *Prometheus) handlerName(c *gin.Context) string {
+	if p.HandlerNameFunc == nil {
+		return HandlerNameShort(c)
 	}
+	return p.HandlerNameFunc(c)
 }
 
-func prometheusHandler() gin.HandlerFunc {
-	h := prometheus.UninstrumentedHandler()
+func (p *Prometheus) prometheusHandler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(p.gatherer, promhttp.HandlerOpts{})
 	return func(c *gin.Context) {
 		h.ServeHTTP(c.Writer, c.Request)
 	}
@@ -0,0 +1,949 @@
+package ginprometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newTestPrometheus() *Prometheus {
+	return NewPrometheus("test", WithRegistry(prometheus.NewRegistry()))
+}
+
+func HandleWidgets(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+// closureHandler is anonymous, so gin reports a compiler-generated name
+// such as "glob..func1" for it.
+var closureHandler = func(c *gin.Context) {
+	c.String(http.StatusOK, c.Param("id"))
+}
+
+// lastSegment mirrors HandlerNameShort for a function value.
+func lastSegment(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func serve(e *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestHandlerName(t *testing.T) {
+	p := newTestPrometheus()
+
+	middleware := p.handlerFunc()
+
+	tests := []struct {
+		name    string
+		fn      HandlerNameFunc
+		path    string
+		code    string
+		handler string
+	}{
+		{"short named", HandlerNameShort, "/widgets", "200", "Widgets"},
+		{"short closure", HandlerNameShort, "/users/42", "200", lastSegment(closureHandler)},
+		{"nil defaults to short", nil, "/widgets", "200", "Widgets"},
+		{"full", HandlerNameFull, "/widgets", "200", "github.com/gwik/go-gin-prometheus.HandleWidgets"},
+		{"route matched", HandlerNameRoute, "/users/42", "200", "/users/:id"},
+		{"route unmatched", HandlerNameRoute, "/missing", "404", lastSegment(middleware)},
+		{"context set", HandlerNameFromContext, "/tagged", "200", "tagged-handler"},
+		{"context unset", HandlerNameFromContext, "/widgets", "200", "Widgets"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.HandlerNameFunc = tt.fn
+
+			e := gin.New()
+			e.Use(middleware)
+			e.GET("/widgets", HandleWidgets)
+			e.GET("/users/:id", closureHandler)
+			e.GET("/tagged", func(c *gin.Context) {
+				SetHandlerName(c, "tagged-handler")
+				c.String(http.StatusOK, "ok")
+			})
+
+			counter := p.reqCnt.WithLabelValues(tt.code, "get", tt.handler)
+			before := testutil.ToFloat64(counter)
+			serve(e, http.MethodGet, tt.path)
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("requests_total{handler=%q} increased by %v, want 1", tt.handler, got)
+			}
+		})
+	}
+}
+
+// histogram reads the sample count and sum of a single histogram series.
+func histogram(t *testing.T, o prometheus.Observer) (uint64, float64) {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := o.(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestTimeToFirstByte(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/slow-tail", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+		time.Sleep(50 * time.Millisecond)
+	})
+
+	serve(e, http.MethodGet, "/slow-tail")
+
+	count, sum := histogram(t, p.ttfb.WithLabelValues("/slow-tail"))
+	if count != 1 {
+		t.Fatalf("time_to_first_byte_seconds count = %d, want 1", count)
+	}
+	if sum >= 0.05 {
+		t.Errorf("time_to_first_byte_seconds = %v, want less than the handler's tail", sum)
+	}
+}
+
+// sampleCount reads the sample count of a summary or histogram.
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := o.(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Histogram != nil {
+		return m.GetHistogram().GetSampleCount()
+	}
+	return m.GetSummary().GetSampleCount()
+}
+
+func TestStreamingExcludedFromLatency(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/events", func(c *gin.Context) {
+		n := 0
+		c.Stream(func(w io.Writer) bool {
+			c.SSEvent("tick", n)
+			n++
+			return n < 3
+		})
+	})
+	e.GET("/ws", func(c *gin.Context) {
+		conn, _, err := c.Writer.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+		conn.Close()
+	})
+
+	durBefore := sampleCount(t, p.reqDur)
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: test\r\n\r\n"))
+	io.ReadAll(conn)
+	conn.Close()
+
+	if got := sampleCount(t, p.reqDur) - durBefore; got != 0 {
+		t.Errorf("request_duration_seconds observed %d streams, want 0", got)
+	}
+	for kind, msgs := range map[string]float64{streamKindFlushed: 3, streamKindHijacked: 1} {
+		handler := map[string]string{streamKindFlushed: "/events", streamKindHijacked: "/ws"}[kind]
+		if got := testutil.ToFloat64(p.activeStreams.WithLabelValues(kind, handler)); got != 0 {
+			t.Errorf("active_streams{kind=%q} = %v, want 0", kind, got)
+		}
+		if got := testutil.ToFloat64(p.streamMsgs.WithLabelValues(kind, handler)); got != msgs {
+			t.Errorf("stream_messages_total{kind=%q} = %v, want %v", kind, got, msgs)
+		}
+		if count, _ := histogram(t, p.streamDur.WithLabelValues(kind, handler)); count != 1 {
+			t.Errorf("stream_duration_seconds{kind=%q} count = %d, want 1", kind, count)
+		}
+	}
+}
+
+func TestRequestOutcome(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+	p.CancelledStatusCode = StatusClientClosedRequest
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/ok", HandleWidgets)
+	e.GET("/denied", func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusForbidden)
+	})
+
+	serve(e, http.MethodGet, "/ok")
+	serve(e, http.MethodGet, "/denied")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil).WithContext(ctx))
+
+	for _, tt := range []struct{ outcome, handler string }{
+		{outcomeCompleted, "/ok"},
+		{outcomeAborted, "/denied"},
+		{outcomeCancelled, "/ok"},
+	} {
+		if got := testutil.ToFloat64(p.reqOutcome.WithLabelValues(tt.outcome, "get", tt.handler)); got != 1 {
+			t.Errorf("request_outcomes_total{outcome=%q,handler=%q} = %v, want 1", tt.outcome, tt.handler, got)
+		}
+	}
+	if got := testutil.ToFloat64(p.reqCnt.WithLabelValues("499", "get", "/ok")); got != 1 {
+		t.Errorf("requests_total{code=\"499\"} = %v, want 1", got)
+	}
+}
+
+func TestProtocolMetrics(t *testing.T) {
+	p := newTestPrometheus()
+	p.EnableProtocolMetrics()
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/widgets", HandleWidgets)
+
+	serve(e, http.MethodGet, "/widgets")
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/widgets", nil))
+
+	if got := testutil.ToFloat64(p.reqProto.WithLabelValues("HTTP/1.1", "none", "http")); got != 1 {
+		t.Errorf("plain requests_protocol_total = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(p.reqProto.WithLabelValues("HTTP/1.1", "TLS 1.2", "https")); got != 1 {
+		t.Errorf("TLS requests_protocol_total = %v, want 1", got)
+	}
+}
+
+func TestInstrumentServer(t *testing.T) {
+	p := newTestPrometheus()
+
+	e := gin.New()
+	e.GET("/widgets", HandleWidgets)
+
+	srv := httptest.NewUnstartedServer(e)
+	p.InstrumentServer(srv.Config)
+	srv.Start()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(srv.URL + "/widgets")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	client.CloseIdleConnections()
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(p.openConns) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := testutil.ToFloat64(p.openConns); got != 0 {
+		t.Errorf("open_connections = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(p.connsNew); got != 1 {
+		t.Errorf("connections_total = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(p.connReuse); got != 1 {
+		t.Errorf("connection_reuses_total = %v, want 1", got)
+	}
+	if count, _ := histogram(t, p.connDur); count != 1 {
+		t.Errorf("connection_duration_seconds count = %d, want 1", count)
+	}
+}
+
+func TestSLO(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+	p.AddSLO(SLO{Name: "api", Route: regexp.MustCompile(`^/api/`), Latency: 20 * time.Millisecond})
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/api/fast", HandleWidgets)
+	e.GET("/api/slow", func(c *gin.Context) {
+		time.Sleep(30 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+	e.GET("/api/broken", func(c *gin.Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+	e.GET("/other", HandleWidgets)
+
+	for _, path := range []string{"/api/fast", "/api/slow", "/api/broken", "/other"} {
+		serve(e, http.MethodGet, path)
+	}
+
+	if got := testutil.ToFloat64(p.sloReqs.WithLabelValues("api")); got != 3 {
+		t.Errorf("slo_requests_total = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(p.sloGood.WithLabelValues("api")); got != 1 {
+		t.Errorf("slo_good_requests_total = %v, want 1", got)
+	}
+}
+
+func TestApdex(t *testing.T) {
+	p := newTestPrometheus()
+	p.SetApdexTarget(time.Second)
+	p.SetRouteApdexTarget("Widgets", 20*time.Millisecond)
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/widgets", func(c *gin.Context) {
+		SetHandlerName(c, "Widgets")
+		if d, err := time.ParseDuration(c.Query("sleep")); err == nil {
+			time.Sleep(d)
+		}
+		if c.Query("fail") != "" {
+			c.Status(http.StatusInternalServerError)
+		}
+	})
+	p.HandlerNameFunc = HandlerNameFromContext
+
+	serve(e, http.MethodGet, "/widgets")
+	serve(e, http.MethodGet, "/widgets?sleep=30ms")
+	serve(e, http.MethodGet, "/widgets?fail=1")
+
+	for _, zone := range []string{apdexSatisfied, apdexTolerating, apdexFrustrated} {
+		if got := testutil.ToFloat64(p.apdex.reqs.WithLabelValues("Widgets", zone)); got != 1 {
+			t.Errorf("apdex_requests_total{zone=%q} = %v, want 1", zone, got)
+		}
+	}
+	if got := testutil.ToFloat64(p.apdex); got != 0.5 {
+		t.Errorf("apdex_score = %v, want 0.5", got)
+	}
+}
+
+func TestOTelMeter(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	p := NewPrometheus("test", WithRegistry(prometheus.NewRegistry()), WithMeter(meter))
+	p.HandlerNameFunc = HandlerNameRoute
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/users/:id", closureHandler)
+
+	serve(e, http.MethodGet, "/users/42")
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	var count metricdata.Sum[int64]
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name == "http.server.request.count" {
+			count = m.Data.(metricdata.Sum[int64])
+		}
+	}
+	if len(count.DataPoints) != 1 || count.DataPoints[0].Value != 1 {
+		t.Fatalf("http.server.request.count = %+v, want a single point of 1", count.DataPoints)
+	}
+	attrs := count.DataPoints[0].Attributes
+	for key, want := range map[attribute.Key]string{
+		"http.request.method":       "GET",
+		"http.route":                "/users/:id",
+		"http.response.status_code": "200",
+	} {
+		if got, _ := attrs.Value(key); got.Emit() != want {
+			t.Errorf("%s = %q, want %q", key, got.Emit(), want)
+		}
+	}
+}
+
+func TestStatsDSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	sink, err := NewStatsDSink(pc.LocalAddr().String(), StatsDOptions{Prefix: "gin.", DogStatsD: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestPrometheus()
+	p.AddSink(sink)
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/widgets", HandleWidgets)
+
+	serve(e, http.MethodGet, "/widgets")
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1500)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(buf[:n]), "\n")
+	if want := "gin.requests_total:1|c|#code:200,method:get,handler:Widgets"; lines[0] != want {
+		t.Errorf("first line = %q, want %q", lines[0], want)
+	}
+	if len(lines) != 4 {
+		t.Errorf("got %d lines, want 4: %q", len(lines), lines)
+	}
+}
+
+func TestInstrumentNetHTTP(t *testing.T) {
+	p := newTestPrometheus()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "ok")
+	})
+	h := p.Instrument(nil)(mux)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/7", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+	if got := testutil.ToFloat64(p.reqCnt.WithLabelValues("201", "get", "GET /items/{id}")); got != 1 {
+		t.Errorf("requests_total for the matched pattern = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(p.reqCnt.WithLabelValues("404", "get", "unmatched")); got != 1 {
+		t.Errorf("requests_total for unmatched requests = %v, want 1", got)
+	}
+}
+
+func TestRoundTripper(t *testing.T) {
+	p := newTestPrometheus()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: p.RoundTripper("ping", &http.Transport{})}
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if got := testutil.ToFloat64(p.clientReqs.WithLabelValues("127.0.0.1", "ping", "202", "get")); got != 1 {
+		t.Errorf("client_requests_total = %v, want 1", got)
+	}
+	for _, phase := range []string{"connect", "ttfb"} {
+		if count, _ := histogram(t, p.clientPhases.WithLabelValues("127.0.0.1", "ping", phase)); count != 1 {
+			t.Errorf("client_request_phase_duration_seconds{phase=%q} count = %d, want 1", phase, count)
+		}
+	}
+	if got := testutil.ToFloat64(p.clientInFlight.WithLabelValues("127.0.0.1", "ping")); got != 0 {
+		t.Errorf("client_in_flight_requests = %v, want 0", got)
+	}
+}
+
+func TestCustomMetrics(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.POST("/orders", func(c *gin.Context) {
+		Counter(c, "orders_created_total").Inc()
+		Observe(c, "order_value_dollars", 12.5)
+	})
+
+	serve(e, http.MethodPost, "/orders")
+	serve(e, http.MethodPost, "/orders")
+
+	if got := testutil.ToFloat64(p.custom.counters["orders_created_total"].WithLabelValues("post", "/orders")); got != 2 {
+		t.Errorf("orders_created_total = %v, want 2", got)
+	}
+	if count, sum := histogram(t, p.custom.histograms["order_value_dollars"].WithLabelValues("post", "/orders")); count != 2 || sum != 25 {
+		t.Errorf("order_value_dollars count, sum = %d, %v, want 2, 25", count, sum)
+	}
+}
+
+func TestPhaseTimers(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+	p.ServerTiming = true
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/render", func(c *gin.Context) {
+		stop := StartPhase(c, "db")
+		time.Sleep(5 * time.Millisecond)
+		stop()
+		StartPhase(c, "cache")()
+		c.String(http.StatusOK, "ok")
+	})
+	e.GET("/empty", func(c *gin.Context) {
+		StartPhase(c, "db")()
+		c.Status(http.StatusNoContent)
+	})
+
+	w := serve(e, http.MethodGet, "/render")
+	header := w.Header().Get("Server-Timing")
+	if !strings.HasPrefix(header, "db;dur=") || !strings.Contains(header, ", cache;dur=") {
+		t.Errorf("Server-Timing = %q, want db and cache phases", header)
+	}
+	if count, sum := histogram(t, p.reqPhases.WithLabelValues("/render", "db")); count != 1 || sum < 0.005 {
+		t.Errorf("request_phase_duration_seconds{phase=\"db\"} count, sum = %d, %v", count, sum)
+	}
+
+	if w := serve(e, http.MethodGet, "/empty"); w.Header().Get("Server-Timing") == "" {
+		t.Error("Server-Timing missing on a bodyless response")
+	}
+}
+
+func TestParseRequestStart(t *testing.T) {
+	want := time.Unix(1700000000, 123000000)
+	for _, value := range []string{
+		"t=1700000000.123",
+		"1700000000.123",
+		"1700000000123",
+		"t=1700000000123000",
+		"1700000000123000000",
+	} {
+		got, err := parseRequestStart(value)
+		if err != nil {
+			t.Errorf("parseRequestStart(%q): %v", value, err)
+			continue
+		}
+		if d := got.Sub(want); d < -time.Microsecond || d > time.Microsecond {
+			t.Errorf("parseRequestStart(%q) = %v, want %v", value, got, want)
+		}
+	}
+	for _, value := range []string{"", "t=", "soon", "-5"} {
+		if _, err := parseRequestStart(value); err == nil {
+			t.Errorf("parseRequestStart(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestQueueTime(t *testing.T) {
+	p := newTestPrometheus()
+	p.EnableQueueTime()
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/widgets", HandleWidgets)
+
+	for _, value := range []string{
+		fmt.Sprintf("t=%d", time.Now().Add(-250*time.Millisecond).UnixMicro()),
+		fmt.Sprintf("%d", time.Now().Add(time.Hour).UnixMilli()),
+		"garbage",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/widgets", nil)
+		r.Header.Set("X-Request-Start", value)
+		e.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if count, sum := histogram(t, p.queueDur); count != 1 || sum < 0.25 || sum > 1 {
+		t.Errorf("request_queue_duration_seconds count, sum = %d, %v, want 1, ~0.25", count, sum)
+	}
+	for _, reason := range []string{"future", "malformed"} {
+		if got := testutil.ToFloat64(p.queueInvalid.WithLabelValues(reason)); got != 1 {
+			t.Errorf("request_queue_invalid_total{reason=%q} = %v, want 1", reason, got)
+		}
+	}
+}
+
+func TestTimedChain(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+
+	auth := func(c *gin.Context) {
+		time.Sleep(10 * time.Millisecond)
+		c.Next()
+	}
+	handler := func(c *gin.Context) {
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/orders", p.Timed("auth", auth), p.Timed("handler", handler))
+
+	serve(e, http.MethodGet, "/orders")
+
+	_, authSum := histogram(t, p.chainDur.WithLabelValues("/orders", "auth"))
+	_, handlerSum := histogram(t, p.chainDur.WithLabelValues("/orders", "handler"))
+	if authSum < 0.01 || authSum >= 0.03 {
+		t.Errorf("auth took %vs, want its own 10ms without the nested handler", authSum)
+	}
+	if handlerSum < 0.03 {
+		t.Errorf("handler took %vs, want at least 30ms", handlerSum)
+	}
+}
+
+func TestNativeHistograms(t *testing.T) {
+	for _, classic := range []bool{false, true} {
+		reg := prometheus.NewRegistry()
+		p := NewPrometheus("test", WithRegistry(reg), WithNativeHistograms(NativeHistogramOpts{Classic: classic}))
+
+		e := gin.New()
+		e.Use(p.handlerFunc())
+		e.GET("/widgets", HandleWidgets)
+		serve(e, http.MethodGet, "/widgets")
+
+		m := &dto.Metric{}
+		if err := p.reqDur.(prometheus.Metric).Write(m); err != nil {
+			t.Fatal(err)
+		}
+		h := m.GetHistogram()
+		if h == nil || h.GetSampleCount() != 1 {
+			t.Fatalf("request_duration_seconds = %v, want a histogram with one sample", m)
+		}
+		if h.Schema == nil {
+			t.Errorf("classic=%v: request_duration_seconds has no native schema", classic)
+		}
+		if got := len(h.GetBucket()) > 0; got != classic {
+			t.Errorf("classic=%v: classic buckets present = %v", classic, got)
+		}
+	}
+}
+
+func TestSummaryObjectives(t *testing.T) {
+	p := NewPrometheus("test",
+		WithRegistry(prometheus.NewRegistry()),
+		WithSummaryConfig("response_size_bytes", SummaryConfig{Objectives: map[float64]float64{0.75: 0.01}, MaxAge: time.Minute}),
+	)
+
+	quantiles := func(o prometheus.Observer) []float64 {
+		m := &dto.Metric{}
+		if err := o.(prometheus.Metric).Write(m); err != nil {
+			t.Fatal(err)
+		}
+		var qs []float64
+		for _, q := range m.GetSummary().GetQuantile() {
+			qs = append(qs, q.GetQuantile())
+		}
+		return qs
+	}
+
+	if got := quantiles(p.reqDur); !reflect.DeepEqual(got, []float64{0.5, 0.9, 0.99}) {
+		t.Errorf("request_duration_seconds quantiles = %v, want the defaults", got)
+	}
+	if got := quantiles(p.resSz); !reflect.DeepEqual(got, []float64{0.75}) {
+		t.Errorf("response_size_bytes quantiles = %v, want [0.75]", got)
+	}
+}
+
+func TestSeriesTTL(t *testing.T) {
+	p := NewPrometheus("test", WithRegistry(prometheus.NewRegistry()), WithSeriesTTL(40*time.Millisecond))
+	defer p.Close()
+	p.HandlerNameFunc = HandlerNameFromContext
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/tenants/:id", func(c *gin.Context) {
+		SetHandlerName(c, "tenant-"+c.Param("id"))
+	})
+
+	// Observe concurrently with the sweeper, for the race detector.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				serve(e, http.MethodGet, fmt.Sprintf("/tenants/%d", i))
+				time.Sleep(time.Millisecond)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := testutil.CollectAndCount(p.reqCnt); got != 4 {
+		t.Fatalf("requests_total has %d series right after traffic, want 4", got)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.CollectAndCount(p.reqCnt) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.CollectAndCount(p.reqCnt); got != 0 {
+		t.Errorf("requests_total has %d series after the TTL, want 0", got)
+	}
+	if got := testutil.ToFloat64(p.series.expired); got < 4 {
+		t.Errorf("series_expired_total = %v, want at least 4", got)
+	}
+}
+
+func TestCardinalityEndpoint(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+	p.CardinalityPath = "/debug/cardinality"
+
+	e := gin.New()
+	p.Use(e)
+	e.GET("/a", HandleWidgets)
+	e.GET("/b", HandleWidgets)
+	serve(e, http.MethodGet, "/a")
+	serve(e, http.MethodGet, "/a")
+	serve(e, http.MethodGet, "/b")
+
+	var report CardinalityReport
+	w := serve(e, http.MethodGet, "/debug/cardinality?format=json&top=1")
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	var reqCnt *MetricCardinality
+	for i := range report.Metrics {
+		if report.Metrics[i].Name == "test_requests_total" {
+			reqCnt = &report.Metrics[i]
+		}
+	}
+	if reqCnt == nil || reqCnt.Series != 2 {
+		t.Fatalf("test_requests_total = %+v, want 2 series", reqCnt)
+	}
+	for _, l := range reqCnt.Labels {
+		if l.Name == "handler" && (l.Values != 2 || len(l.Top) != 1) {
+			t.Errorf("handler label = %+v, want 2 values and the top one", l)
+		}
+	}
+
+	w = serve(e, http.MethodGet, "/debug/cardinality")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") || !strings.Contains(w.Body.String(), "test_requests_total") {
+		t.Errorf("HTML page: Content-Type %q, body %q", ct, w.Body.String())
+	}
+}
+
+func TestJSONEndpoint(t *testing.T) {
+	p := newTestPrometheus()
+	p.JSONPath = "/metrics.json"
+
+	e := gin.New()
+	p.Use(e)
+	e.GET("/widgets", HandleWidgets)
+	serve(e, http.MethodGet, "/widgets")
+
+	w := serve(e, http.MethodGet, "/metrics.json?name=test_requests_total&name=test_request_duration_seconds&name=test_time_to_first_byte_seconds")
+	var families []JSONFamily
+	if err := json.Unmarshal(w.Body.Bytes(), &families); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	if len(families) != 3 {
+		t.Fatalf("got %d families, want 3", len(families))
+	}
+
+	byName := make(map[string]map[string]interface{})
+	var raw []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &raw)
+	for _, f := range raw {
+		byName[f["name"].(string)] = f["metrics"].([]interface{})[0].(map[string]interface{})
+	}
+	if v := byName["test_requests_total"]["value"]; v != 1.0 {
+		t.Errorf("requests_total value = %v, want 1", v)
+	}
+	if q := byName["test_request_duration_seconds"]["quantiles"].([]interface{}); len(q) != 3 {
+		t.Errorf("request_duration_seconds quantiles = %v, want 3", q)
+	}
+	buckets := byName["test_time_to_first_byte_seconds"]["buckets"].([]interface{})
+	if last := buckets[len(buckets)-1].(map[string]interface{}); last["upper_bound"] != "+Inf" || last["count"] != 1.0 {
+		t.Errorf("last bucket = %v, want +Inf with a count of 1", last)
+	}
+}
+
+func TestRollingStats(t *testing.T) {
+	s := newRollingStats()
+	now := time.Now()
+	for i := 1; i <= 100; i++ {
+		code := http.StatusOK
+		if i%10 == 0 {
+			code = http.StatusBadGateway
+		}
+		s.observe(now, "orders", code, time.Duration(i)*time.Millisecond, false)
+	}
+	s.observe(now.Add(-10*time.Minute), "stale", http.StatusOK, time.Millisecond, false)
+
+	stats := s.snapshot(now, time.Minute, "")
+	if _, ok := stats["stale"]; ok {
+		t.Error("a handler last seen 10 minutes ago is in the 1m window")
+	}
+	rs := stats["orders"]
+	if rs.Requests != 100 || rs.ErrorRatio != 0.1 {
+		t.Errorf("requests, error ratio = %d, %v, want 100, 0.1", rs.Requests, rs.ErrorRatio)
+	}
+	for _, q := range []struct {
+		got, want time.Duration
+	}{{rs.P50, 50 * time.Millisecond}, {rs.P90, 90 * time.Millisecond}, {rs.P99, 99 * time.Millisecond}} {
+		if q.got < q.want || float64(q.got) > float64(q.want)*rollingBucketFactor {
+			t.Errorf("quantile = %v, want within one bucket above %v", q.got, q.want)
+		}
+	}
+	if _, ok := s.snapshot(now, 15*time.Minute, "")["stale"]; !ok {
+		t.Error("a handler seen 10 minutes ago is missing from the 15m window")
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+	p.DashboardPath = "/debug/routes"
+
+	e := gin.New()
+	p.Use(e)
+	e.GET("/widgets", HandleWidgets)
+	serve(e, http.MethodGet, "/widgets")
+
+	w := serve(e, http.MethodGet, "/debug/routes?window=1m")
+	if body := w.Body.String(); !strings.Contains(body, "<td>/widgets</td><td>1</td>") {
+		t.Errorf("dashboard does not list /widgets: %s", body)
+	}
+}
+
+func TestRouteStatsAPI(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+	if p.RouteStats(time.Minute) != nil {
+		t.Error("RouteStats is not nil before EnableRollingStats")
+	}
+	p.EnableRollingStats()
+
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/widgets", HandleWidgets)
+	e.GET("/broken", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+	serve(e, http.MethodGet, "/widgets")
+	serve(e, http.MethodGet, "/broken")
+
+	for _, window := range []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute} {
+		stats := p.RouteStats(window)
+		if len(stats) != 2 || stats["/widgets"].Requests != 1 || stats["/broken"].ErrorRatio != 1 {
+			t.Errorf("RouteStats(%v) = %+v", window, stats)
+		}
+	}
+	if rs, ok := p.HandlerStats("/widgets", time.Minute); !ok || rs.RequestsPerSecond <= 0 {
+		t.Errorf("HandlerStats(/widgets) = %+v, %v", rs, ok)
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	p := newTestPrometheus()
+	p.HandlerNameFunc = HandlerNameRoute
+	p.EnableLoadShedding(LoadShedding{
+		MaxInFlight: 1,
+		RetryAfter:  1500 * time.Millisecond,
+		Priority: func(c *gin.Context) Priority {
+			if c.GetHeader("X-Critical") != "" {
+				return PriorityCritical
+			}
+			return PriorityNormal
+		},
+	})
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	e := gin.New()
+	e.Use(p.handlerFunc())
+	e.GET("/block", func(c *gin.Context) {
+		close(entered)
+		<-release
+	})
+	e.GET("/widgets", HandleWidgets)
+
+	done := make(chan struct{})
+	go func() {
+		serve(e, http.MethodGet, "/block")
+		close(done)
+	}()
+	<-entered
+
+	w := serve(e, http.MethodGet, "/widgets")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("second request: code %d, Retry-After %q, want 503 and 2", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/widgets", nil)
+	r.Header.Set("X-Critical", "1")
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("critical request: code %d, want 200", w.Code)
+	}
+
+	close(release)
+	<-done
+
+	if w := serve(e, http.MethodGet, "/widgets"); w.Code != http.StatusOK {
+		t.Errorf("request after the load: code %d, want 200", w.Code)
+	}
+	if got := testutil.ToFloat64(p.shed.shed.WithLabelValues("/widgets", "in_flight")); got != 1 {
+		t.Errorf("requests_shed_total = %v, want 1", got)
+	}
+}
+
+func TestWriteRules(t *testing.T) {
+	p := NewPrometheus("test", WithRegistry(prometheus.NewRegistry()), WithNativeHistograms(NativeHistogramOpts{}))
+	p.AddSLO(SLO{Name: "availability", Objective: 0.999})
+	p.AddSLO(SLO{Name: "latency"})
+
+	cfg := p.RulesConfig()
+	cfg.Selector = `job="api"`
+	var b strings.Builder
+	if err := WriteRules(&b, cfg); err != nil {
+		t.Fatal(err)
+	}
+	rules := b.String()
+
+	for _, want := range []string{
+		`- record: handler:test_requests_total:rate5m`,
+		`sum by (handler) (rate(test_requests_total{job="api",code=~"5.."}[5m]))`,
+		`histogram_quantile(0.99, sum(rate(test_request_duration_seconds{job="api"}[5m])))`,
+		`sum(rate(test_slo_good_requests_total{job="api",slo="latency"}[3d]))`,
+		`slo:sli_error:ratio_rate1h{slo="availability"} > 0.0144`,
+		`slo:sli_error:ratio_rate6h{slo="availability"} > 0.001`,
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("rules do not contain %q:\n%s", want, rules)
+		}
+	}
+	if got := strings.Count(rules, "- alert: "); got != 4 {
+		t.Errorf("%d alerts, want 4 for the one SLO with an objective", got)
+	}
+}
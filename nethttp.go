@@ -0,0 +1,99 @@
+package ginprometheus
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// RouteFunc returns the handler label for a plain net/http request. It is
+// called after the wrapped handler returned, so routers that store the
+// matched route on the request or its context can be read.
+type RouteFunc func(r *http.Request) string
+
+// RoutePattern uses the pattern matched by http.ServeMux, and "unmatched"
+// for requests that did not go through a mux.
+func RoutePattern(r *http.Request) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	return "unmatched"
+}
+
+// Instrument returns a net/http middleware recording the same metrics as
+// the gin middleware, with the handler label supplied by route. It
+// defaults to RoutePattern when route is nil. The signature fits chi's
+// Use:
+//
+//	r.Use(p.Instrument(func(r *http.Request) string {
+//		return chi.RouteContext(r.Context()).RoutePattern()
+//	}))
+func (p *Prometheus) Instrument(route RouteFunc) func(http.Handler) http.Handler {
+	if route == nil {
+		route = RoutePattern
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			o := p.begin(r, rw.Header(), func() string { return route(r) })
+			w := &httpResponseWriter{ResponseWriter: rw, o: o, status: http.StatusOK}
+
+			next.ServeHTTP(w, r)
+
+			o.finish(w.status, w.size, false)
+		})
+	}
+}
+
+// httpResponseWriter is the net/http counterpart of responseWriter, it
+// also keeps the status and size gin tracks itself.
+type httpResponseWriter struct {
+	http.ResponseWriter
+
+	o           *observation
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func (w *httpResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+		w.o.markFirstByte()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *httpResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	w.o.markFirstByte()
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *httpResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		w.o.flushed()
+		f.Flush()
+	}
+}
+
+func (w *httpResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ginprometheus: the ResponseWriter does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+	return w.o.hijack(conn), rw, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *httpResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,96 @@
+package ginprometheus
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// WithMeter records the request count, duration and body sizes through an
+// OpenTelemetry meter as well, using the semantic convention names and the
+// http.request.method, http.route and http.response.status_code attributes.
+// The handler label is used as http.route. It panics if the instruments
+// cannot be created, as registering a Prometheus collector does.
+func WithMeter(meter metric.Meter) Option {
+	return func(p *Prometheus) {
+		o, err := newOTelSink(meter)
+		if err != nil {
+			panic(err)
+		}
+		p.sinks = append(p.sinks, o)
+	}
+}
+
+// WithoutPrometheus stops exposing Prometheus metrics, for use with
+// WithMeter when only OTLP is ingested. Collectors are registered with a
+// private registry that is never served, and Use no longer mounts
+// MetricsPath.
+func WithoutPrometheus() Option {
+	return func(p *Prometheus) {
+		p.registerer = prometheus.NewRegistry()
+		p.gatherer = nil
+	}
+}
+
+type otelSink struct {
+	reqCnt       metric.Int64Counter
+	reqDur       metric.Float64Histogram
+	reqSz, resSz metric.Int64Histogram
+}
+
+func newOTelSink(meter metric.Meter) (*otelSink, error) {
+	var (
+		o   otelSink
+		err error
+	)
+	if o.reqCnt, err = meter.Int64Counter(
+		"http.server.request.count",
+		metric.WithDescription("How many HTTP requests processed."),
+		metric.WithUnit("{request}"),
+	); err != nil {
+		return nil, err
+	}
+	if o.reqDur, err = meter.Float64Histogram(
+		"http.server.request.duration",
+		metric.WithDescription("Duration of HTTP server requests."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+	if o.reqSz, err = meter.Int64Histogram(
+		"http.server.request.body.size",
+		metric.WithDescription("Size of HTTP server request bodies."),
+		metric.WithUnit("By"),
+	); err != nil {
+		return nil, err
+	}
+	if o.resSz, err = meter.Int64Histogram(
+		"http.server.response.body.size",
+		metric.WithDescription("Size of HTTP server response bodies."),
+		metric.WithUnit("By"),
+	); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (o *otelSink) Record(r *Request) {
+	// The request context may already be cancelled, which must not stop
+	// the measurement from being recorded.
+	ctx := context.WithoutCancel(r.Context)
+	attrs := metric.WithAttributes(
+		attribute.String("http.request.method", r.Method),
+		attribute.String("http.route", r.Handler),
+		attribute.Int("http.response.status_code", r.Code),
+	)
+	o.reqCnt.Add(ctx, 1, attrs)
+	if !r.Stream {
+		o.reqDur.Record(ctx, r.Duration.Seconds(), attrs)
+	}
+	if r.RequestBodySize >= 0 {
+		o.reqSz.Record(ctx, r.RequestBodySize, attrs)
+	}
+	o.resSz.Record(ctx, int64(r.ResponseSize), attrs)
+}
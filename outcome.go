@@ -0,0 +1,26 @@
+package ginprometheus
+
+import "net/http"
+
+// StatusClientClosedRequest is the non-standard status nginx uses for
+// requests the client gave up on. See Prometheus.CancelledStatusCode.
+const StatusClientClosedRequest = 499
+
+const (
+	outcomeCompleted = "completed"
+	outcomeAborted   = "aborted"
+	outcomeCancelled = "cancelled"
+)
+
+// requestOutcome classifies a finished request. A client that went away
+// takes precedence over a middleware that called c.Abort.
+func requestOutcome(r *http.Request, aborted bool) string {
+	switch {
+	case r.Context().Err() != nil:
+		return outcomeCancelled
+	case aborted:
+		return outcomeAborted
+	default:
+		return outcomeCompleted
+	}
+}
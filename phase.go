@@ -0,0 +1,64 @@
+package ginprometheus
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type phaseTiming struct {
+	name string
+	dur  time.Duration
+}
+
+// StartPhase starts timing a phase of the current request, such as "db" or
+// "render", and returns the function that stops it:
+//
+//	defer ginprometheus.StartPhase(c, "db")()
+//
+// The duration is recorded in request_phase_duration_seconds and, with
+// ServerTiming enabled, in the Server-Timing response header if the phase
+// ended before the headers were written.
+func StartPhase(c *gin.Context, phase string) func() {
+	o, ok := c.Value(observationKey).(*observation)
+	if !ok {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		o.endPhase(phase, time.Since(start))
+	}
+}
+
+func (o *observation) endPhase(phase string, d time.Duration) {
+	handler := o.handler()
+	o.p.series.touch(o.p.reqPhases, handler, phase)
+	o.p.reqPhases.WithLabelValues(handler, phase).Observe(d.Seconds())
+
+	o.mu.Lock()
+	o.phases = append(o.phases, phaseTiming{name: phase, dur: d})
+	o.mu.Unlock()
+}
+
+// writeServerTiming sets the Server-Timing header from the phases that
+// ended so far. It must be called before the headers are written.
+func (o *observation) writeServerTiming() {
+	if !o.p.ServerTiming {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.phases) == 0 {
+		return
+	}
+	metrics := make([]string, len(o.phases))
+	for i, ph := range o.phases {
+		ms := float64(ph.dur) / float64(time.Millisecond)
+		metrics[i] = ph.name + ";dur=" + strconv.FormatFloat(ms, 'f', 3, 64)
+	}
+	o.header.Add("Server-Timing", strings.Join(metrics, ", "))
+}
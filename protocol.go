@@ -0,0 +1,32 @@
+package ginprometheus
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// EnableProtocolMetrics registers requests_protocol_total, which counts
+// requests by negotiated HTTP protocol, TLS version and scheme.
+func (p *Prometheus) EnableProtocolMetrics() {
+	if p.reqProto != nil {
+		return
+	}
+	p.reqProto = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "requests_protocol_total",
+			Help:      "How many HTTP requests processed, partitioned by protocol, TLS version and scheme.",
+		},
+		[]string{"proto", "tls_version", "scheme"},
+	)
+	p.registerer.MustRegister(p.reqProto)
+}
+
+func requestProtocol(r *http.Request) (proto, tlsVersion, scheme string) {
+	if r.TLS == nil {
+		return r.Proto, "none", "http"
+	}
+	return r.Proto, tls.VersionName(r.TLS.Version), "https"
+}
@@ -0,0 +1,91 @@
+package ginprometheus
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// EnableQueueTime registers request_queue_duration_seconds, the time
+// requests waited upstream before reaching the middleware, as stamped by a
+// load balancer in the X-Request-Start or X-Queue-Start header. Accepted
+// formats are an optional "t=" prefix followed by a Unix timestamp in
+// seconds (with or without fraction), milliseconds, microseconds or
+// nanoseconds, the unit being inferred from the magnitude.
+//
+// Timestamps in the future, from clock skew between the hosts, are not
+// observed. They are counted in request_queue_invalid_total with reason
+// "future", and unparsable headers with reason "malformed".
+func (p *Prometheus) EnableQueueTime() {
+	if p.queueDur != nil {
+		return
+	}
+	p.queueDur = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      "request_queue_duration_seconds",
+			Help:      "The time HTTP requests waited upstream before being handled, in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		},
+	)
+	p.registerer.MustRegister(p.queueDur)
+
+	p.queueInvalid = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "request_queue_invalid_total",
+			Help:      "How many request start headers were ignored, partitioned by reason.",
+		},
+		[]string{"reason"},
+	)
+	p.registerer.MustRegister(p.queueInvalid)
+}
+
+func (p *Prometheus) observeQueueTime(r *http.Request, now time.Time) {
+	value := r.Header.Get("X-Request-Start")
+	if value == "" {
+		value = r.Header.Get("X-Queue-Start")
+	}
+	if value == "" {
+		return
+	}
+
+	queued, err := parseRequestStart(value)
+	if err != nil {
+		p.queueInvalid.WithLabelValues("malformed").Inc()
+		return
+	}
+	wait := now.Sub(queued)
+	if wait < 0 {
+		p.queueInvalid.WithLabelValues("future").Inc()
+		return
+	}
+	p.queueDur.Observe(wait.Seconds())
+}
+
+var errRequestStart = errors.New("ginprometheus: malformed request start header")
+
+func parseRequestStart(value string) (time.Time, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "t=")
+	ts, err := strconv.ParseFloat(value, 64)
+	if err != nil || ts <= 0 {
+		return time.Time{}, errRequestStart
+	}
+
+	var unit float64
+	switch {
+	case ts < 1e11:
+		unit = float64(time.Second)
+	case ts < 1e14:
+		unit = float64(time.Millisecond)
+	case ts < 1e17:
+		unit = float64(time.Microsecond)
+	default:
+		unit = float64(time.Nanosecond)
+	}
+	return time.Unix(0, int64(ts*unit)), nil
+}
@@ -0,0 +1,200 @@
+package ginprometheus
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	rollingSlotSize = 10 * time.Second
+	rollingSlots    = 90 // 15 minutes
+
+	// Latency buckets grow by rollingBucketFactor from rollingMinLatency,
+	// the last one holds everything slower.
+	rollingMinLatency   = 100 * time.Microsecond
+	rollingBucketFactor = 1.25
+	rollingBuckets      = 72
+)
+
+// rollingSlot holds the requests of one rollingSlotSize interval.
+type rollingSlot struct {
+	epoch    int64
+	requests uint64
+	errors   uint64
+	latency  [rollingBuckets]uint32
+}
+
+// rollingWindow is a ring of slots covering the last 15 minutes of one
+// handler.
+type rollingWindow struct {
+	slots [rollingSlots]rollingSlot
+}
+
+// rollingStats maintains per-handler request counts, errors and latency
+// distributions in process.
+type rollingStats struct {
+	mu      sync.Mutex
+	windows map[string]*rollingWindow
+}
+
+func newRollingStats() *rollingStats {
+	return &rollingStats{windows: make(map[string]*rollingWindow)}
+}
+
+// observe records a request. Streams are counted but their duration is
+// left out of the latency distribution.
+func (s *rollingStats) observe(now time.Time, handler string, code int, elapsed time.Duration, stream bool) {
+	epoch := now.UnixNano() / int64(rollingSlotSize)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[handler]
+	if !ok {
+		w = &rollingWindow{}
+		s.windows[handler] = w
+	}
+	slot := &w.slots[epoch%rollingSlots]
+	if slot.epoch != epoch {
+		*slot = rollingSlot{epoch: epoch}
+	}
+	slot.requests++
+	if code >= 500 && code <= 599 {
+		slot.errors++
+	}
+	if !stream {
+		slot.latency[latencyBucket(elapsed)]++
+	}
+}
+
+func latencyBucket(d time.Duration) int {
+	if d <= rollingMinLatency {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(rollingMinLatency)) / math.Log(rollingBucketFactor)))
+	if i >= rollingBuckets {
+		return rollingBuckets - 1
+	}
+	return i
+}
+
+// latencyBucketBound is the upper bound of bucket i.
+func latencyBucketBound(i int) time.Duration {
+	return time.Duration(float64(rollingMinLatency) * math.Pow(rollingBucketFactor, float64(i)))
+}
+
+// RouteStats summarizes the requests of a handler over a window. Latency
+// quantiles exclude streaming and hijacked responses and are estimated
+// from buckets 25% apart, rounding up.
+type RouteStats struct {
+	Requests          uint64
+	RequestsPerSecond float64
+
+	// ErrorRatio is the share of requests that failed with a 5xx.
+	ErrorRatio float64
+
+	P50, P90, P99 time.Duration
+}
+
+// EnableRollingStats keeps per-handler statistics over the last 15 minutes
+// in process, for RouteStats. It must be called before serving requests.
+func (p *Prometheus) EnableRollingStats() {
+	if p.stats == nil {
+		p.stats = newRollingStats()
+	}
+}
+
+// RouteStats returns the statistics of every handler seen during the last
+// window, typically time.Minute, 5*time.Minute or 15*time.Minute. The
+// window is rounded up to 10 seconds and capped at 15 minutes. It returns
+// nil unless EnableRollingStats was called or DashboardPath is set.
+func (p *Prometheus) RouteStats(window time.Duration) map[string]RouteStats {
+	if p.stats == nil {
+		return nil
+	}
+	return p.stats.snapshot(time.Now(), window, "")
+}
+
+// HandlerStats is RouteStats for a single handler label value.
+func (p *Prometheus) HandlerStats(handler string, window time.Duration) (RouteStats, bool) {
+	if p.stats == nil {
+		return RouteStats{}, false
+	}
+	rs, ok := p.stats.snapshot(time.Now(), window, handler)[handler]
+	return rs, ok
+}
+
+// snapshot summarizes every handler seen during the last window, which is
+// rounded up to whole slots and capped at 15 minutes, or only handler when
+// it is not empty.
+func (s *rollingStats) snapshot(now time.Time, window time.Duration, only string) map[string]RouteStats {
+	slots := int64((window + rollingSlotSize - 1) / rollingSlotSize)
+	if slots < 1 {
+		slots = 1
+	}
+	if slots > rollingSlots {
+		slots = rollingSlots
+	}
+	epoch := now.UnixNano() / int64(rollingSlotSize)
+
+	// The current slot is only partly elapsed.
+	span := time.Duration(slots-1)*rollingSlotSize + now.Sub(time.Unix(0, epoch*int64(rollingSlotSize)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]RouteStats, len(s.windows))
+	for handler, w := range s.windows {
+		if only != "" && handler != only {
+			continue
+		}
+		var (
+			requests, errors uint64
+			latency          [rollingBuckets]uint64
+			observed         uint64
+		)
+		for i := range w.slots {
+			slot := &w.slots[i]
+			if epoch-slot.epoch >= slots {
+				continue
+			}
+			requests += slot.requests
+			errors += slot.errors
+			for b, n := range slot.latency {
+				latency[b] += uint64(n)
+				observed += uint64(n)
+			}
+		}
+		if requests == 0 {
+			continue
+		}
+
+		rs := RouteStats{
+			Requests:          requests,
+			RequestsPerSecond: float64(requests) / span.Seconds(),
+			ErrorRatio:        float64(errors) / float64(requests),
+		}
+		if observed > 0 {
+			rs.P50 = latencyQuantile(&latency, observed, 0.5)
+			rs.P90 = latencyQuantile(&latency, observed, 0.9)
+			rs.P99 = latencyQuantile(&latency, observed, 0.99)
+		}
+		stats[handler] = rs
+	}
+	return stats
+}
+
+// latencyQuantile returns the upper bound of the bucket holding quantile
+// q, so the estimate errs on the slow side by at most one bucket.
+func latencyQuantile(latency *[rollingBuckets]uint64, total uint64, q float64) time.Duration {
+	rank := uint64(math.Ceil(q * float64(total)))
+	var cumulative uint64
+	for i, n := range latency {
+		cumulative += n
+		if cumulative >= rank {
+			return latencyBucketBound(i)
+		}
+	}
+	return latencyBucketBound(rollingBuckets - 1)
+}
@@ -0,0 +1,156 @@
+package ginprometheus
+
+import (
+	"io"
+	"strconv"
+	"text/template"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RulesConfig describes the metrics of an instance to WriteRules.
+type RulesConfig struct {
+	// Namespace and Subsystem prefix the metric names, as in
+	// prometheus.BuildFQName.
+	Namespace string
+	Subsystem string
+
+	// Selector restricts every query, e.g. `job="api"`.
+	Selector string
+
+	// NativeHistograms adds p99 latency rules, which summaries cannot be
+	// aggregated into.
+	NativeHistograms bool
+
+	// SLOs get error ratio rules, and burn-rate alerts if their Objective
+	// is set.
+	SLOs []RuleSLO
+}
+
+// RuleSLO is the part of an SLO that rules are generated from.
+type RuleSLO struct {
+	Name      string
+	Objective float64
+}
+
+// RulesConfig returns the configuration of p for WriteRules.
+func (p *Prometheus) RulesConfig() RulesConfig {
+	cfg := RulesConfig{
+		Subsystem:        p.subsystem,
+		NativeHistograms: p.native != nil,
+	}
+	for _, slo := range p.slos {
+		cfg.SLOs = append(cfg.SLOs, RuleSLO{Name: slo.Name, Objective: slo.Objective})
+	}
+	return cfg
+}
+
+// burnRateAlert is one multi-window, multi-burn-rate alert as recommended
+// by the Google SRE workbook: it fires when both windows burn the error
+// budget faster than Factor.
+type burnRateAlert struct {
+	Long, Short string
+	Factor      float64
+	Severity    string
+}
+
+var burnRateAlerts = []burnRateAlert{
+	{Long: "1h", Short: "5m", Factor: 14.4, Severity: "page"},
+	{Long: "6h", Short: "30m", Factor: 6, Severity: "page"},
+	{Long: "1d", Short: "2h", Factor: 3, Severity: "ticket"},
+	{Long: "3d", Short: "6h", Factor: 1, Severity: "ticket"},
+}
+
+var sloWindows = []string{"5m", "30m", "1h", "2h", "6h", "1d", "3d"}
+
+// WriteRules writes a Prometheus rules file with recording rules for the
+// request rate, error ratio and latency of each handler, the error ratio
+// of each SLO, and burn-rate alerts for the SLOs with an objective.
+func WriteRules(w io.Writer, cfg RulesConfig) error {
+	return rulesTemplate.Execute(w, cfg)
+}
+
+var rulesTemplate = template.Must(template.New("rules").Funcs(template.FuncMap{
+	"metric": func(cfg RulesConfig, name string) string {
+		return prometheus.BuildFQName(cfg.Namespace, cfg.Subsystem, name)
+	},
+	"group": func(cfg RulesConfig) string {
+		return prometheus.BuildFQName(cfg.Namespace, cfg.Subsystem, "http")
+	},
+	"sel": func(cfg RulesConfig, matchers ...string) string {
+		s := cfg.Selector
+		for _, m := range matchers {
+			if s != "" {
+				s += ","
+			}
+			s += m
+		}
+		if s == "" {
+			return ""
+		}
+		return "{" + s + "}"
+	},
+	"quote":     strconv.Quote,
+	"budget":    func(objective, factor float64) string { return strconv.FormatFloat(factor*(1-objective), 'g', 6, 64) },
+	"windows":   func() []string { return sloWindows },
+	"burnRates": func() []burnRateAlert { return burnRateAlerts },
+}).Parse(`{{- $cfg := . -}}
+{{- $reqs := metric $cfg "requests_total" -}}
+{{- $dur := metric $cfg "request_duration_seconds" -}}
+groups:
+- name: {{group $cfg}}.rules
+  rules:
+  - record: handler:{{$reqs}}:rate5m
+    expr: sum by (handler) (rate({{$reqs}}{{sel $cfg}}[5m]))
+  - record: handler_code:{{$reqs}}:rate5m
+    expr: sum by (handler, code) (rate({{$reqs}}{{sel $cfg}}[5m]))
+  - record: handler:{{$reqs}}:error_ratio_rate5m
+    expr: |-
+      sum by (handler) (rate({{$reqs}}{{sel $cfg "code=~\"5..\""}}[5m]))
+      /
+      sum by (handler) (rate({{$reqs}}{{sel $cfg}}[5m]))
+  - record: {{$dur}}:mean5m
+    expr: |-
+      sum(rate({{$dur}}_sum{{sel $cfg}}[5m]))
+      /
+      sum(rate({{$dur}}_count{{sel $cfg}}[5m]))
+{{- if $cfg.NativeHistograms}}
+  - record: {{$dur}}:p99_5m
+    expr: histogram_quantile(0.99, sum(rate({{$dur}}{{sel $cfg}}[5m])))
+{{- end}}
+{{- if $cfg.SLOs}}
+{{- $total := metric $cfg "slo_requests_total"}}
+{{- $good := metric $cfg "slo_good_requests_total"}}
+- name: {{group $cfg}}.slo.rules
+  rules:
+{{- range $slo := $cfg.SLOs}}
+{{- range $w := windows}}
+  - record: slo:sli_error:ratio_rate{{$w}}
+    expr: |-
+      1 - (
+        sum(rate({{$good}}{{sel $cfg (printf "slo=%s" (quote $slo.Name))}}[{{$w}}]))
+        /
+        sum(rate({{$total}}{{sel $cfg (printf "slo=%s" (quote $slo.Name))}}[{{$w}}]))
+      )
+    labels:
+      slo: {{quote $slo.Name}}
+{{- end}}
+{{- end}}
+{{- range $slo := $cfg.SLOs}}
+{{- if $slo.Objective}}
+{{- range $a := burnRates}}
+  - alert: SLOErrorBudgetBurn
+    expr: |-
+      slo:sli_error:ratio_rate{{$a.Long}}{slo={{quote $slo.Name}}} > {{budget $slo.Objective $a.Factor}}
+      and
+      slo:sli_error:ratio_rate{{$a.Short}}{slo={{quote $slo.Name}}} > {{budget $slo.Objective $a.Factor}}
+    labels:
+      severity: {{$a.Severity}}
+      slo: {{quote $slo.Name}}
+    annotations:
+      summary: {{quote (printf "SLO %s is burning its error budget %vx too fast over %s" $slo.Name $a.Factor $a.Long)}}
+{{- end}}
+{{- end}}
+{{- end}}
+{{- end}}
+`))
@@ -0,0 +1,158 @@
+package ginprometheus
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Priority decides how early a request is shed under load.
+type Priority int
+
+const (
+	// PriorityNormal requests are shed at the configured limits.
+	PriorityNormal Priority = iota
+
+	// PriorityLow requests are shed at LowPriorityFraction of the limits.
+	PriorityLow
+
+	// PriorityCritical requests are never shed.
+	PriorityCritical
+)
+
+// LoadShedding configures the admission control of EnableLoadShedding.
+type LoadShedding struct {
+	// MaxInFlight sheds requests while that many are being served.
+	// Zero disables the limit.
+	MaxInFlight int
+
+	// MaxP99 sheds requests to a handler whose p99 latency over the last
+	// minute exceeds it. Zero disables the limit.
+	MaxP99 time.Duration
+
+	// RetryAfter is sent in the Retry-After header of shed requests,
+	// rounded up to whole seconds. Defaults to one second.
+	RetryAfter time.Duration
+
+	// Priority classifies requests, before the handlers run. Defaults to
+	// PriorityNormal for every request.
+	Priority func(c *gin.Context) Priority
+
+	// LowPriorityFraction scales both limits for PriorityLow requests.
+	// Defaults to 0.5.
+	LowPriorityFraction float64
+}
+
+// latencyCacheTTL bounds how often the p99 of a handler is recomputed from
+// the rolling statistics.
+const latencyCacheTTL = time.Second
+
+type cachedLatency struct {
+	p99     time.Duration
+	expires time.Time
+}
+
+type loadShedder struct {
+	LoadShedding
+
+	inFlight int64
+	shed     *prometheus.CounterVec
+
+	mu        sync.Mutex
+	latencies map[string]cachedLatency
+}
+
+// EnableLoadShedding rejects requests with 503 Service Unavailable and a
+// Retry-After header when too many are in flight or the handler's rolling
+// p99 latency is too high. Shed requests are counted in
+// requests_shed_total. It must be called before serving requests.
+func (p *Prometheus) EnableLoadShedding(cfg LoadShedding) {
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = time.Second
+	}
+	if cfg.LowPriorityFraction <= 0 {
+		cfg.LowPriorityFraction = 0.5
+	}
+	if cfg.MaxP99 > 0 {
+		p.EnableRollingStats()
+	}
+
+	if p.shed == nil {
+		p.shed = &loadShedder{
+			shed: prometheus.NewCounterVec(
+				prometheus.CounterOpts{
+					Subsystem: p.subsystem,
+					Name:      "requests_shed_total",
+					Help:      "How many HTTP requests were rejected by load shedding, partitioned by handler and reason.",
+				},
+				[]string{"handler", "reason"},
+			),
+			latencies: make(map[string]cachedLatency),
+		}
+		p.registerer.MustRegister(p.shed.shed)
+	}
+	p.shed.LoadShedding = cfg
+}
+
+// admit reports whether c may be served, rejecting it otherwise. It must be
+// paired with release when it returns true.
+func (p *Prometheus) admit(c *gin.Context) bool {
+	s := p.shed
+	inFlight := atomic.AddInt64(&s.inFlight, 1)
+
+	priority := PriorityNormal
+	if s.Priority != nil {
+		priority = s.Priority(c)
+	}
+	if priority == PriorityCritical {
+		return true
+	}
+	scale := 1.0
+	if priority == PriorityLow {
+		scale = s.LowPriorityFraction
+	}
+
+	handler := p.handlerName(c)
+	reason := ""
+	switch {
+	case s.MaxInFlight > 0 && float64(inFlight) > float64(s.MaxInFlight)*scale:
+		reason = "in_flight"
+	case s.MaxP99 > 0 && float64(p.handlerP99(handler)) > float64(s.MaxP99)*scale:
+		reason = "latency"
+	default:
+		return true
+	}
+
+	atomic.AddInt64(&s.inFlight, -1)
+	p.series.touch(s.shed, handler, reason)
+	s.shed.WithLabelValues(handler, reason).Inc()
+
+	retryAfter := (s.RetryAfter + time.Second - 1) / time.Second
+	c.Header("Retry-After", strconv.FormatInt(int64(retryAfter), 10))
+	c.AbortWithStatus(http.StatusServiceUnavailable)
+	return false
+}
+
+func (p *Prometheus) release() {
+	atomic.AddInt64(&p.shed.inFlight, -1)
+}
+
+func (p *Prometheus) handlerP99(handler string) time.Duration {
+	s := p.shed
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cached, ok := s.latencies[handler]; ok && now.Before(cached.expires) {
+		return cached.p99
+	}
+	rs, _ := p.HandlerStats(handler, time.Minute)
+	s.latencies[handler] = cachedLatency{p99: rs.P99, expires: now.Add(latencyCacheTTL)}
+	return rs.P99
+}
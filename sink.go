@@ -0,0 +1,69 @@
+package ginprometheus
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Request is the measurement of a served request handed to each Sink.
+type Request struct {
+	// Context is the request's context. It may already be cancelled.
+	Context context.Context
+
+	// Method is the HTTP method as sent by the client.
+	Method string
+
+	// Handler is the handler label computed by HandlerNameFunc.
+	Handler string
+
+	// Code is the recorded status code, see CancelledStatusCode.
+	Code int
+
+	Duration time.Duration
+
+	// Stream is true for streaming and hijacked responses, whose Duration
+	// is not a request latency.
+	Stream bool
+
+	// RequestSize approximates the whole request, headers included.
+	RequestSize int
+
+	// RequestBodySize is the request's Content-Length, -1 when unknown.
+	RequestBodySize int64
+
+	ResponseSize int
+}
+
+// Sink records the core request metrics: count, duration and sizes.
+// Record is called on the request path and must not block.
+type Sink interface {
+	Record(r *Request)
+}
+
+// AddSink records every request to s as well. It must be called before
+// serving requests.
+func (p *Prometheus) AddSink(s Sink) {
+	p.sinks = append(p.sinks, s)
+}
+
+// promSink records the core metrics served on MetricsPath.
+type promSink struct {
+	series               *seriesTracker
+	reqCnt               *prometheus.CounterVec
+	reqDur, reqSz, resSz prometheus.Observer
+}
+
+func (s *promSink) Record(r *Request) {
+	if !r.Stream {
+		s.reqDur.Observe(r.Duration.Seconds())
+	}
+	code, method := strconv.Itoa(r.Code), strings.ToLower(r.Method)
+	s.series.touch(s.reqCnt, code, method, r.Handler)
+	s.reqCnt.WithLabelValues(code, method, r.Handler).Inc()
+	s.reqSz.Observe(float64(r.RequestSize))
+	s.resSz.Observe(float64(r.ResponseSize))
+}
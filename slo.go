@@ -0,0 +1,88 @@
+package ginprometheus
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SLO defines which requests count towards an objective and which of those
+// are good: a request is good when its status code is not an error code
+// and, if Latency is set, it completed within Latency.
+type SLO struct {
+	// Name is the value of the slo label.
+	Name string
+
+	// Route is matched against the handler label. Nil matches every request.
+	Route *regexp.Regexp
+
+	// Latency is the threshold for a good request. Zero disables it.
+	Latency time.Duration
+
+	// ErrorCodes are the status codes of bad requests. Defaults to 5xx.
+	ErrorCodes []int
+
+	// Objective is the target ratio of good requests, e.g. 0.999. It is
+	// only used to generate burn-rate alerts, see WriteRules.
+	Objective float64
+}
+
+func (s SLO) matches(handler string) bool {
+	return s.Route == nil || s.Route.MatchString(handler)
+}
+
+func (s SLO) good(code int, elapsed time.Duration) bool {
+	if s.Latency > 0 && elapsed > s.Latency {
+		return false
+	}
+	if s.ErrorCodes == nil {
+		return code < 500 || code > 599
+	}
+	for _, c := range s.ErrorCodes {
+		if c == code {
+			return false
+		}
+	}
+	return true
+}
+
+// AddSLO adds an objective reported as slo_requests_total and
+// slo_good_requests_total. It must be called before serving requests.
+// Streaming and hijacked responses are not counted.
+func (p *Prometheus) AddSLO(slo SLO) {
+	if p.sloReqs == nil {
+		p.sloReqs = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: p.subsystem,
+				Name:      "slo_requests_total",
+				Help:      "How many HTTP requests counted towards an SLO.",
+			},
+			[]string{"slo"},
+		)
+		p.registerer.MustRegister(p.sloReqs)
+
+		p.sloGood = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: p.subsystem,
+				Name:      "slo_good_requests_total",
+				Help:      "How many HTTP requests met an SLO's status code and latency thresholds.",
+			},
+			[]string{"slo"},
+		)
+		p.registerer.MustRegister(p.sloGood)
+	}
+	p.slos = append(p.slos, slo)
+}
+
+func (p *Prometheus) observeSLOs(handler string, code int, elapsed time.Duration) {
+	for _, slo := range p.slos {
+		if !slo.matches(handler) {
+			continue
+		}
+		p.sloReqs.WithLabelValues(slo.Name).Inc()
+		if slo.good(code, elapsed) {
+			p.sloGood.WithLabelValues(slo.Name).Inc()
+		}
+	}
+}
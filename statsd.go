@@ -0,0 +1,179 @@
+package ginprometheus
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsDOptions configures a StatsDSink.
+type StatsDOptions struct {
+	// Prefix is prepended to every metric name, e.g. "gin.".
+	Prefix string
+
+	// DogStatsD sends code, method and handler as tags. Plain StatsD has no
+	// tags, so they are appended to the metric name instead.
+	DogStatsD bool
+
+	// FlushInterval bounds how long a metric waits in the buffer.
+	// Defaults to 100ms.
+	FlushInterval time.Duration
+
+	// MaxPacketSize is the largest UDP payload sent. Defaults to 1432.
+	MaxPacketSize int
+
+	// QueueSize is how many metrics may wait to be sent before new ones
+	// are dropped. Defaults to 4096.
+	QueueSize int
+}
+
+// StatsDSink emits the core request metrics over UDP in the StatsD or
+// DogStatsD line format. Record never blocks: lines are queued, batched
+// into packets by a background goroutine and dropped when the queue is
+// full.
+type StatsDSink struct {
+	opts    StatsDOptions
+	conn    net.Conn
+	lines   chan string
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+}
+
+// NewStatsDSink dials addr, e.g. "127.0.0.1:8125", and starts flushing.
+func NewStatsDSink(addr string, opts StatsDOptions) (*StatsDSink, error) {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 100 * time.Millisecond
+	}
+	if opts.MaxPacketSize <= 0 {
+		opts.MaxPacketSize = 1432
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 4096
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StatsDSink{
+		opts:  opts,
+		conn:  conn,
+		lines: make(chan string, opts.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *StatsDSink) Record(r *Request) {
+	tags := [...]string{
+		"code", strconv.Itoa(r.Code),
+		"method", strings.ToLower(r.Method),
+		"handler", r.Handler,
+	}
+	s.emit("requests_total", "1", "c", tags[:])
+	if !r.Stream {
+		s.emit("request_duration", strconv.FormatFloat(float64(r.Duration)/float64(time.Millisecond), 'f', -1, 64), "ms", tags[:])
+	}
+	s.emit("request_size_bytes", strconv.Itoa(r.RequestSize), "h", tags[:])
+	s.emit("response_size_bytes", strconv.Itoa(r.ResponseSize), "h", tags[:])
+}
+
+// Dropped returns how many metrics were dropped because the queue was full.
+func (s *StatsDSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close flushes queued metrics and closes the connection. Record must not
+// be called afterwards.
+func (s *StatsDSink) Close() error {
+	s.once.Do(func() {
+		close(s.lines)
+		<-s.done
+	})
+	return s.conn.Close()
+}
+
+func (s *StatsDSink) emit(name, value, typ string, tags []string) {
+	var b strings.Builder
+	b.WriteString(s.opts.Prefix)
+	b.WriteString(name)
+	if !s.opts.DogStatsD {
+		for i := 1; i < len(tags); i += 2 {
+			b.WriteByte('.')
+			b.WriteString(strings.Replace(statsdSanitize(tags[i]), ".", "_", -1))
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(typ)
+	if s.opts.DogStatsD {
+		b.WriteString("|#")
+		for i := 0; i < len(tags); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(tags[i])
+			b.WriteByte(':')
+			b.WriteString(statsdSanitize(tags[i+1]))
+		}
+	}
+
+	select {
+	case s.lines <- b.String():
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *StatsDSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	buf := make([]byte, 0, s.opts.MaxPacketSize)
+	flush := func() {
+		if len(buf) > 0 {
+			// Losing a packet is preferable to stalling, errors are ignored.
+			s.conn.Write(buf)
+			buf = buf[:0]
+		}
+	}
+
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				flush()
+				return
+			}
+			if len(buf) > 0 && len(buf)+1+len(line) > s.opts.MaxPacketSize {
+				flush()
+			}
+			if len(buf) > 0 {
+				buf = append(buf, '\n')
+			}
+			buf = append(buf, line...)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// statsdSanitize replaces the characters that delimit the line format.
+func statsdSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '@', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,73 @@
+package ginprometheus
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	streamKindFlushed  = "flushed"
+	streamKindHijacked = "hijacked"
+)
+
+// stream tracks a long-lived response: one that was flushed mid-request
+// (SSE, c.Stream) or whose connection was hijacked (WebSocket).
+type stream struct {
+	p             *Prometheus
+	kind, handler string
+	start         time.Time
+	once          sync.Once
+}
+
+func (p *Prometheus) startStream(kind, handler string) *stream {
+	p.activeStreams.WithLabelValues(kind, handler).Inc()
+	return &stream{p: p, kind: kind, handler: handler, start: time.Now()}
+}
+
+func (s *stream) message() {
+	s.p.series.touch(s.p.streamMsgs, s.kind, s.handler)
+	s.p.streamMsgs.WithLabelValues(s.kind, s.handler).Inc()
+}
+
+func (s *stream) bytes(direction string, n int) {
+	if n > 0 {
+		s.p.series.touch(s.p.streamBytes, s.kind, s.handler, direction)
+		s.p.streamBytes.WithLabelValues(s.kind, s.handler, direction).Add(float64(n))
+	}
+}
+
+func (s *stream) end() {
+	s.once.Do(func() {
+		s.p.activeStreams.WithLabelValues(s.kind, s.handler).Dec()
+		s.p.series.touch(s.p.streamDur, s.kind, s.handler)
+		s.p.streamDur.WithLabelValues(s.kind, s.handler).Observe(time.Since(s.start).Seconds())
+	})
+}
+
+// streamConn counts the traffic on a hijacked connection and ends its
+// stream when the connection is closed, which may be long after the
+// handler returned. Bytes that pass through the bufio.ReadWriter returned
+// alongside it are not seen.
+type streamConn struct {
+	net.Conn
+	stream *stream
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stream.bytes("in", n)
+	return n, err
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stream.message()
+	c.stream.bytes("out", n)
+	return n, err
+}
+
+func (c *streamConn) Close() error {
+	c.stream.end()
+	return c.Conn.Close()
+}
@@ -0,0 +1,44 @@
+package ginprometheus
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseWriter reports to the observation when the response headers or
+// first body byte are written, and whether the response became a stream.
+type responseWriter struct {
+	gin.ResponseWriter
+
+	o *observation
+}
+
+func (w *responseWriter) WriteHeaderNow() {
+	w.o.markFirstByte()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.o.markFirstByte()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	w.o.markFirstByte()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseWriter) Flush() {
+	w.o.flushed()
+	w.ResponseWriter.Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+	return w.o.hijack(conn), rw, nil
+}